@@ -1,6 +1,7 @@
 package aliyun
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/tiechui1994/tool/aliyun/aliyundrive"
+	"github.com/tiechui1994/tool/util"
 )
 
 func FindDriveDrive(path string, accesstoken aliyundrive.Token) (file aliyundrive.File, err error) {
@@ -17,6 +19,7 @@ func FindDriveDrive(path string, accesstoken aliyundrive.Token) (file aliyundriv
 		return file, errors.New("invalid path")
 	}
 
+	accesstoken = accesstoken.WithSource(nil)
 	tokens := strings.Split(path[1:], "/")
 	files, err := aliyundrive.Files("root", accesstoken)
 	if err != nil {
@@ -70,7 +73,9 @@ type DriveFs struct {
 	pwd         string
 }
 
+// NewDriveFs accesstoken 没有设置 Source 时自动设置, access_token 过期或者 401 时刷新(不保存刷新之后的 token)
 func NewDriveFs(accesstoken aliyundrive.Token) *DriveFs {
+	accesstoken = accesstoken.WithSource(nil)
 	p := DriveFs{rootid: "root", accesstoken: accesstoken, pwd: "/"}
 
 	p.root = &FileNode{
@@ -469,30 +474,21 @@ func (d *DriveFs) Upload(path, target string) error {
 		return nil
 	})
 
-	wg := sync.WaitGroup{}
-	count := 0
+	pool := util.NewPool(context.Background(), util.WithPoolMode(util.BestEffort))
 	for dir, files := range dirpaths {
 		target := filepath.Join(target, dir[len(absdir):])
 		for _, file := range files {
-			count++
-			wg.Add(1)
 			f := file
-			go func() {
-				defer wg.Done()
-				d.fileupload(f, target)
-			}()
-			if count == 5 {
-				wg.Wait()
-				count = 0
-			}
+			pool.Go(func(ctx context.Context) error {
+				if err := d.fileupload(f, target); err != nil {
+					return fmt.Errorf("upload %v: %w", f, err)
+				}
+				return nil
+			})
 		}
 	}
 
-	if count > 0 {
-		wg.Wait()
-	}
-
-	return nil
+	return pool.Wait()
 }
 
 func (d *DriveFs) List(path string) ([]*FileNode, error) {
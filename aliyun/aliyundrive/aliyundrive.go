@@ -1,6 +1,7 @@
 package aliyundrive
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/md5"
@@ -35,6 +36,7 @@ var (
 	header = map[string]string{
 		"content-type": "application/json",
 	}
+	logger = log.Named("aliyundrive")
 )
 
 func calProof(accesstoken string, path string) string {
@@ -68,6 +70,64 @@ type Token struct {
 	UserID       string `json:"user_id"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+
+	// Source 不为空时, 请求使用 Source 的 access_token, 过期或者 401 时自动刷新
+	Source *util.TokenSource `json:"-"`
+}
+
+// NewTokenSource 创建基于 refresh_token 刷新的 TokenSource, persist 保存刷新之后的 token
+func NewTokenSource(token Token, persist func(token Token)) *util.TokenSource {
+	current := util.Token{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+	}
+	if token.ExpiresIn > 0 {
+		current.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	var opts []util.TokenSourceOption
+	if persist != nil {
+		opts = append(opts, util.WithTokenPersist(func(t util.Token) {
+			val := token
+			val.Source = nil
+			val.AccessToken = t.AccessToken
+			val.RefreshToken = t.RefreshToken
+			val.ExpiresIn = int(time.Until(t.Expiry).Seconds())
+			persist(val)
+		}))
+	}
+
+	return util.NewTokenSource(current, func(t util.Token) (util.Token, error) {
+		val, err := Refresh(t.RefreshToken)
+		if err != nil {
+			return t, err
+		}
+
+		return util.Token{
+			AccessToken:  val.AccessToken,
+			RefreshToken: val.RefreshToken,
+			Expiry:       time.Now().Add(time.Duration(val.ExpiresIn) * time.Second),
+		}, nil
+	}, opts...)
+}
+
+// WithSource 返回设置了 Source 的 token, 请求过期或者 401 时自动刷新. 已经设置 Source 时直接返回.
+func (t Token) WithSource(persist func(token Token)) Token {
+	if t.Source == nil {
+		t.Source = NewTokenSource(t, persist)
+	}
+	return t
+}
+
+// accessToken 返回当前的 access_token, 设置 Source 时使用 Source 中(可能已经刷新)的 token
+func (t Token) accessToken() string {
+	if t.Source != nil {
+		if val, err := t.Source.Token(); err == nil {
+			return val.AccessToken
+		}
+	}
+	return t.AccessToken
 }
 
 func Refresh(refresh string) (token Token, err error) {
@@ -144,13 +204,13 @@ func initState(token Token) {
 		body := fmt.Sprintf(`{"deviceName":"Chrome浏览器","modelName":"Linux网页版","pubKey":"%v"}`, pubKey)
 		header := map[string]string{
 			"accept":        "application/json",
-			"authorization": "Bearer " + token.AccessToken,
+			"authorization": "Bearer " + token.accessToken(),
 			"content-type":  "application/json",
 			"X-Canary":      "client=web,app=adrive,version=v4.3.1",
 			"x-device-id":   token.DeviceID,
 			"X-Signature":   state.signature,
 		}
-		raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithRetry(3))
+		raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source), util.WithRetry(3))
 		if err != nil {
 			fmt.Printf("create_session failed: %v \n", err)
 			os.Exit(1)
@@ -211,7 +271,7 @@ func Files(parentFileID string, token Token) (list []File, err error) {
 	body.ImageThumbnailProcess = "image/resize,w_400/format,jpeg"
 	body.VideoThumbnailProcess = "video/snapshot,t_0,f_jpg,ar_auto,w_800"
 	raw, err := util.POST(u,
-		util.WithHeader(header),
+		util.WithHeader(header), util.WithTokenSource(token.Source),
 		util.WithBody(body),
 		util.WithRetry(3))
 	if err != nil {
@@ -240,7 +300,7 @@ func FileInfo(fileid string, token Token) (file File, err error) {
 
 	body.DriveID = token.DriveID
 	body.FileID = fileid
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	if err != nil {
 		return file, err
 	}
@@ -280,6 +340,11 @@ const (
 )
 
 func CreateWithFolder(checkmode, name, filetype, fileid string, token Token, args map[string]interface{}, path ...string) (
+	upload UploadFolderInfo, err error) {
+	return createWithFolder(context.Background(), checkmode, name, filetype, fileid, token, args, path...)
+}
+
+func createWithFolder(ctx context.Context, checkmode, name, filetype, fileid string, token Token, args map[string]interface{}, path ...string) (
 	upload UploadFolderInfo, err error) {
 	u := yunpan + "/adrive/v2/file/createWithFolders"
 	header := commonHeader(token)
@@ -293,7 +358,7 @@ func CreateWithFolder(checkmode, name, filetype, fileid string, token Token, arg
 	}
 
 	if filetype == TYPE_FOLDER {
-		raw, err := util.POST(u, util.WithHeader(header), util.WithBody(body))
+		raw, err := util.POST(u, util.WithHeader(header), util.WithTokenSource(token.Source), util.WithBody(body), util.WithContext(ctx))
 		if err != nil {
 			return upload, err
 		}
@@ -327,12 +392,12 @@ func CreateWithFolder(checkmode, name, filetype, fileid string, token Token, arg
 			"size":              args["size"],
 			"part_info_list":    args["part_info_list"],
 			"proof_version":     "v1",
-			"proof_code":        calProof(token.AccessToken, path[0]),
+			"proof_code":        calProof(token.accessToken(), path[0]),
 			"content_hash_name": "sha1",
 			"content_hash":      sha1sum,
 		}
 
-		raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+		raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source), util.WithContext(ctx))
 		if err != nil {
 			return upload, err
 		}
@@ -348,7 +413,7 @@ func CreateWithFolder(checkmode, name, filetype, fileid string, token Token, arg
 	body["pre_hash"] = args["pre_hash"]
 	body["size"] = args["size"]
 	body["part_info_list"] = args["part_info_list"]
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source), util.WithContext(ctx))
 	if err != nil {
 		// pre_hash match
 		if val, ok := err.(util.CodeError); ok && val.Code == http.StatusConflict {
@@ -361,7 +426,19 @@ func CreateWithFolder(checkmode, name, filetype, fileid string, token Token, arg
 	return upload, err
 }
 
+// withJob 生成任务 ID, 返回带有任务 ID 的 logger 和 ctx. 任务中的请求使用返回的 ctx, 请求的日志也会带有任务 ID.
+func withJob(ctx context.Context) (*log.Logger, context.Context) {
+	jobLogger := logger.With(log.FromContext(ctx).Fields(), "job", util.NewID())
+	return jobLogger, log.WithContext(ctx, jobLogger)
+}
+
 func UploadFile(path, fileid string, token Token) (id string, err error) {
+	return UploadFileContext(context.Background(), path, fileid, token)
+}
+
+// UploadFileContext 上传文件, 日志中带有任务 ID 以及 ctx 中 Logger 的字段
+func UploadFileContext(ctx context.Context, path, fileid string, token Token) (id string, err error) {
+	logger, ctx := withJob(ctx)
 	info, err := os.Stat(path)
 	if err != nil {
 		return id, err
@@ -391,28 +468,28 @@ func UploadFile(path, fileid string, token Token) (id string, err error) {
 		"size":           info.Size(),
 		"part_info_list": partlist,
 	}
-	upload, err := CreateWithFolder(rename_mode, info.Name(), TYPE_FILE, fileid, token, args, path)
+	upload, err := createWithFolder(ctx, rename_mode, info.Name(), TYPE_FILE, fileid, token, args, path)
 	if err != nil {
 		return id, err
 	}
 
-	log.Infoln("upload file=%q fileid=%v prehash=%v", path, upload.FileID, prehash)
+	logger.Infoln("upload file=%q fileid=%v prehash=%v", path, upload.FileID, prehash)
 	if upload.RapidUpload {
-		log.Infoln("upload file=%q has exist")
+		logger.Infoln("upload file=%q has exist", path)
 		return upload.FileID, nil
 	}
 
-	log.Infoln("upload file=%q chunks: %d", path, len(upload.PartInfoList))
+	logger.Infoln("upload file=%q chunks: %d", path, len(upload.PartInfoList))
 	for k := 0; k < len(upload.PartInfoList); k += 1 {
 		info := upload.PartInfoList[k]
-		log.Infoln("upload file=%q chunk: %d, size: %d", path, info.PartNumber, m10)
-		err = uploadFilePart(info.UploadUrl, fd, int64((info.PartNumber-1)*m10), m10)
+		logger.Infoln("upload file=%q chunk: %d, size: %d", path, info.PartNumber, m10)
+		err = uploadFilePart(ctx, info.UploadUrl, fd, int64((info.PartNumber-1)*m10), m10)
 		if err != nil {
 			return upload.FileID, err
 		}
 	}
 
-	log.Infoln("upload file=%q complete", path)
+	logger.Infoln("upload file=%q complete", path)
 	u := yunpan + "/v2/file/complete"
 	header := commonHeader(token)
 	body := map[string]string{
@@ -420,15 +497,16 @@ func UploadFile(path, fileid string, token Token) (id string, err error) {
 		"file_id":   upload.FileID,
 		"upload_id": upload.UploadID,
 	}
-	_, err = util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithRetry(3))
+	_, err = util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source), util.WithRetry(3),
+		util.WithContext(ctx))
 	return upload.FileID, err
 }
 
-func uploadFilePart(uploadUrl string, file *os.File, start, length int64) error {
+func uploadFilePart(ctx context.Context, uploadUrl string, file *os.File, start, length int64) error {
 	data := make([]byte, length)
 	n, _ := file.ReadAt(data, start)
 
-	_, err := util.PUT(uploadUrl, util.WithBody(data[:n]), util.WithRetry(3))
+	_, err := util.PUT(uploadUrl, util.WithBody(data[:n]), util.WithRetry(3), util.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -456,7 +534,7 @@ func Batch(requests []batchRequest, token Token) error {
 		"requests": requests,
 		"resource": "file",
 	}
-	_, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	_, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	return err
 }
 
@@ -530,7 +608,7 @@ func Rename(name, fileid string, token Token) error {
 		"name":            name,
 	}
 
-	_, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	_, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	return err
 }
 
@@ -543,13 +621,17 @@ type DownloadUrl struct {
 }
 
 func GetDownloadUrl(file File, token Token) (du DownloadUrl, err error) {
+	return getDownloadUrl(context.Background(), file, token)
+}
+
+func getDownloadUrl(ctx context.Context, file File, token Token) (du DownloadUrl, err error) {
 	u := yunpan + "/v2/file/get_download_url"
 	body := map[string]interface{}{
 		"file_id":  file.FileID,
 		"drive_id": file.DriveID,
 	}
 	header := commonHeader(token)
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source), util.WithContext(ctx))
 	if err != nil {
 		return du, err
 	}
@@ -559,7 +641,13 @@ func GetDownloadUrl(file File, token Token) (du DownloadUrl, err error) {
 }
 
 func Download(file File, parallel int, dir string, token Token) error {
-	du, err := GetDownloadUrl(file, token)
+	return DownloadContext(context.Background(), file, parallel, dir, token)
+}
+
+// DownloadContext 下载文件, 日志中带有任务 ID 以及 ctx 中 Logger 的字段
+func DownloadContext(ctx context.Context, file File, parallel int, dir string, token Token) error {
+	logger, ctx := withJob(ctx)
+	du, err := getDownloadUrl(ctx, file, token)
 	if err != nil {
 		return err
 	}
@@ -582,17 +670,17 @@ func Download(file File, parallel int, dir string, token Token) error {
 		return err
 	}
 
-	log.Infoln("download file=%q size=%v sha1=%v ", file.Name, file.Size, file.Hash)
+	logger.Infoln("download file=%q size=%v sha1=%v ", file.Name, file.Size, file.Hash)
 	var group singleflight.Group
 	var wg sync.WaitGroup
 	count := 0
-	log.Infoln("download file=%q parallel=%v batch %v", file.Name, parallel, batch)
+	logger.Infoln("download file=%q parallel=%v batch %v", file.Name, parallel, batch)
 	for i := uint(0); i < batch; i++ {
 		wg.Add(1)
 		count += 1
 		go func(idx uint) {
 			defer wg.Done()
-			log.Infoln("download file=%q batch index %v", file.Name, idx)
+			logger.Infoln("download file=%q batch index %v", file.Name, idx)
 			retry := 1
 		again:
 			from := idx * m32
@@ -605,13 +693,13 @@ func Download(file File, parallel int, dir string, token Token) error {
 				"referer":    "https://www.aliyundrive.com/",
 				"range":      fmt.Sprintf("bytes=%v-%v", from, to),
 			}
-			raw, err := util.GET(du.Url, util.WithHeader(header))
+			raw, err := util.GET(du.Url, util.WithHeader(header), util.WithContext(ctx))
 			if err != nil {
-				if strings.Contains(err.Error(), "Forbidden") && retry <= 3 {
+				if util.IsForbidden(err) && retry <= 3 {
 					retry += 1
 					val, err, _ := group.Do("url", func() (interface{}, error) {
-						log.Errorln("download file=%q batch index %v error: %v", i, err)
-						return GetDownloadUrl(file, token)
+						logger.Errorln("download file=%q batch index %v error: %v", file.Name, idx, err)
+						return getDownloadUrl(ctx, file, token)
 					})
 					if err == nil {
 						du = val.(DownloadUrl)
@@ -621,7 +709,7 @@ func Download(file File, parallel int, dir string, token Token) error {
 				}
 				return
 			}
-			log.Infoln("download file=%q batch index %v success", file.Name, idx)
+			logger.Infoln("download file=%q batch index %v success", file.Name, idx)
 			fd.WriteAt(raw, int64(from))
 			fd.Sync()
 		}(i)
@@ -634,7 +722,7 @@ func Download(file File, parallel int, dir string, token Token) error {
 	if count > 0 {
 		wg.Wait()
 	}
-	log.Infoln("download file=%q complete", file.Name)
+	logger.Infoln("download file=%q complete", file.Name)
 
 	return nil
 }
@@ -659,7 +747,7 @@ func Share(fileidlist []string, token Token) (share ShareInfo, err error) {
 		"file_id_list": fileidlist,
 	}
 
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	if err != nil {
 		return share, err
 	}
@@ -678,7 +766,7 @@ func ShareList(token Token) (list []ShareInfo, err error) {
 		"order_direction":  "DESC",
 	}
 
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	if err != nil {
 		return list, err
 	}
@@ -731,7 +819,7 @@ func Search(fileid string, token Token) (list []File, err error) {
 	body.ImageThumbnailProcess = "image/resize,w_400/format,jpeg"
 	body.VideoThumbnailProcess = "video/snapshot,t_0,f_jpg,ar_auto,w_1000"
 	body.Query = "type = \"file\""
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	if err != nil {
 		return list, err
 	}
@@ -768,7 +856,7 @@ func Create(checkmode, name, filetype, fileid string, token Token, appendargs ma
 		}
 	}
 
-	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header))
+	raw, err := util.POST(u, util.WithBody(body), util.WithHeader(header), util.WithTokenSource(token.Source))
 	if err != nil {
 		// pre_hash match
 		if val, ok := err.(util.CodeError); ok && val.Code == http.StatusConflict {
@@ -789,7 +877,7 @@ func Create(checkmode, name, filetype, fileid string, token Token, appendargs ma
 				"size":              appendargs["size"],
 				"part_info_list":    appendargs["part_info_list"],
 				"proof_version":     "v1",
-				"proof_code":        calProof(token.AccessToken, path[0]),
+				"proof_code":        calProof(token.accessToken(), path[0]),
 				"content_hash_name": "sha1",
 				"content_hash":      strings.ToUpper(hex.EncodeToString(sh.Sum(nil))),
 			}
@@ -813,6 +901,7 @@ func UploadImage(path string, token Token) error {
 		if err != nil {
 			return err
 		}
+		defer fd.Close()
 
 		data := make([]byte, 1024) // 1K, prehash
 		fd.Read(data)
@@ -847,8 +936,11 @@ func UploadImage(path string, token Token) error {
 		for _, part := range upload.PartInfoList {
 			info := part
 			data := make([]byte, m10)
-			fd.ReadAt(data, int64((info.PartNumber-1)*m10))
-			util.PUT(info.UploadUrl, util.WithBody(data), util.WithRetry(2))
+			n, _ := fd.ReadAt(data, int64((info.PartNumber-1)*m10))
+			_, err = util.PUT(info.UploadUrl, util.WithBody(data[:n]), util.WithRetry(2))
+			if err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -876,26 +968,16 @@ func UploadImage(path string, token Token) error {
 		files = append(files, path)
 	}
 
-	wg := sync.WaitGroup{}
-	count := 0
-	for i := 0; i < len(files); i++ {
-		path := files[i]
-		count += 1
-		wg.Add(1)
-		go func(path string) {
-			defer wg.Done()
-			imageUpload(path)
-		}(path)
-
-		if count == 5 {
-			wg.Wait()
-			count = 0
-		}
-	}
-
-	if count > 0 {
-		wg.Wait()
+	pool := util.NewPool(context.Background(), util.WithPoolMode(util.BestEffort))
+	for _, path := range files {
+		path := path
+		pool.Go(func(ctx context.Context) error {
+			if err := imageUpload(path); err != nil {
+				return fmt.Errorf("upload %v: %w", path, err)
+			}
+			return nil
+		})
 	}
 
-	return nil
+	return pool.Wait()
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -21,8 +22,8 @@ type Node struct {
 }
 
 func buildRoot(drive *quark.DriverQuark) (*Node, error) {
-	fetch := func(name, pid string) (*Node, error) {
-		files, err := drive.List(pid)
+	fetch := func(ctx context.Context, name, pid string) (*Node, error) {
+		files, err := drive.ListContext(ctx, pid)
 		if err != nil {
 			return nil, err
 		}
@@ -34,30 +35,44 @@ func buildRoot(drive *quark.DriverQuark) (*Node, error) {
 		}, nil
 	}
 
-	root, err := fetch("", "")
+	root, err := fetch(context.Background(), "", "")
 	if err != nil {
 		return nil, err
 	}
 
-	nodes := []*Node{root}
-	for len(nodes) > 0 {
-		node := nodes[0]
-		nodes = nodes[1:]
-
+	// 并发遍历目录树, 任意目录获取失败时停止
+	var mux sync.Mutex
+	pool := util.NewPool(context.Background(), util.WithPoolConcurrency(8))
+	var walk func(node *Node)
+	walk = func(node *Node) {
 		for _, file := range node.Files {
 			if file.File {
 				continue
 			}
 
-			child, err := fetch(file.FileName, file.Fid)
-			if err != nil {
-				return nil, err
-			}
-			node.Child[file.FileName] = child
-			nodes = append(nodes, child)
+			file := file
+			pool.Go(func(ctx context.Context) error {
+				// 其他目录获取失败时 ctx 被取消, 不再发起请求
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				child, err := fetch(ctx, file.FileName, file.Fid)
+				if err != nil {
+					return err
+				}
+				mux.Lock()
+				node.Child[file.FileName] = child
+				mux.Unlock()
+				walk(child)
+				return nil
+			})
 		}
 	}
+	walk(root)
 
+	if err = pool.Wait(); err != nil {
+		return nil, err
+	}
 	return root, nil
 }
 
@@ -113,16 +128,20 @@ func Download(down quark.Download, parallel int, dir string) error {
 		return err
 	}
 
-	log.Infoln("download file=%q size=%v ", down.FileName, down.Size)
+	// 任务 ID 出现在下载的每一行日志中, 包括 util 的请求日志
+	logger := logger.With("job", util.NewID())
+	ctx := log.WithContext(context.Background(), logger)
+
+	logger.Infoln("download file=%q size=%v ", down.FileName, down.Size)
 	var wg sync.WaitGroup
 	count := 0
-	log.Infoln("download file=%q parallel=%v batch %v", down.FileName, parallel, batch)
+	logger.Infoln("download file=%q parallel=%v batch %v", down.FileName, parallel, batch)
 	for i := uint(0); i < batch; i++ {
 		wg.Add(1)
 		count += 1
 		go func(idx uint) {
 			defer wg.Done()
-			log.Infoln("download file=%q batch index %v", down.FileName, idx)
+			logger.Infoln("download file=%q batch index %v", down.FileName, idx)
 			from := idx * m32
 			to := (idx+1)*m32 - 1
 			if to >= uint(down.Size) {
@@ -134,11 +153,11 @@ func Download(down quark.Download, parallel int, dir string) error {
 			for k, v := range down.Header {
 				header[k] = v[0]
 			}
-			raw, err := util.GET(down.DownloadUrl, util.WithHeader(header))
+			raw, err := util.GET(down.DownloadUrl, util.WithHeader(header), util.WithContext(ctx))
 			if err != nil {
 				return
 			}
-			log.Infoln("download file=%q batch index %v success", down.FileName, idx)
+			logger.Infoln("download file=%q batch index %v success", down.FileName, idx)
 			_, _ = fd.WriteAt(raw, int64(from))
 			_ = fd.Sync()
 		}(i)
@@ -151,11 +170,13 @@ func Download(down quark.Download, parallel int, dir string) error {
 	if count > 0 {
 		wg.Wait()
 	}
-	log.Infoln("download file=%q complete", down.FileName)
+	logger.Infoln("download file=%q complete", down.FileName)
 
 	return nil
 }
 
+var logger = log.Named("quark")
+
 func main() {
 	cookie := flag.String("cookie", "", "quark cookie")
 	path := flag.String("path", "", "quark download path")
@@ -1,6 +1,7 @@
 package quark
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -61,6 +62,11 @@ type Download struct {
 }
 
 func (d *DriverQuark) List(pid string) ([]File, error) {
+	return d.ListContext(context.Background(), pid)
+}
+
+// ListContext 获取目录下的所有文件, ctx 取消时停止分页请求
+func (d *DriverQuark) ListContext(ctx context.Context, pid string) ([]File, error) {
 	files := make([]File, 0)
 	page := 1
 	size := 100
@@ -92,7 +98,7 @@ func (d *DriverQuark) List(pid string) ([]File, error) {
 		raw, err := d.client.GET(d.api+"/file/sort?"+query.Encode(), util.WithHeader(map[string]string{
 			"Accept":  "application/json, text/plain, */*",
 			"Referer": d.referer,
-		}))
+		}), util.WithContext(ctx))
 		if err != nil {
 			return nil, err
 		}
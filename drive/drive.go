@@ -31,6 +31,10 @@ var config struct {
 	tokenuri     string
 }
 
+var source *util.TokenSource
+
+var logger = log.Named("drive")
+
 func init() {
 	// Load token from file if exists, otherwise use empty tokens
 	// Tokens should be obtained through OAuth flow, not hardcoded
@@ -42,6 +46,35 @@ func init() {
 	}
 
 	config.tokenuri = "https://oauth2.googleapis.com/token"
+
+	source = util.NewTokenSource(util.Token{
+		AccessToken:  config.AccessToken,
+		RefreshToken: config.RefreshToken,
+		Expiry:       config.Expired,
+	}, refreshAccessToken, util.WithTokenPersist(saveToken))
+}
+
+func saveToken(token util.Token) {
+	config.AccessToken = token.AccessToken
+	config.RefreshToken = token.RefreshToken
+	config.Expired = token.Expiry
+
+	logger.Debugln("AccessToken:%v", maskToken(config.AccessToken))
+	logger.Debugln("RefreshToken:%v", maskToken(config.RefreshToken))
+	logger.Debugln("Expired:%v", config.Expired.Local())
+
+	data, _ := json.Marshal(config)
+	if err := os.WriteFile("/tmp/token", data, 0600); err != nil {
+		logger.Errorln("save token failed: %v", err)
+	}
+}
+
+// maskToken 日志中只保留 token 的前 4 个字符
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "***"
+	}
+	return token[:4] + "***"
 }
 
 func BuildAuthorizeUri() (uri string, err error) {
@@ -62,7 +95,7 @@ func BuildAuthorizeUri() (uri string, err error) {
 	u := google + "/oauthplayground/buildAuthorizeUri"
 	data, err := util.POST(u, util.WithBody(body))
 	if err != nil {
-		log.Errorln("BuildAuthorizeUri:", err)
+		logger.Errorln("BuildAuthorizeUri: %v", err)
 		return uri, err
 	}
 
@@ -95,7 +128,7 @@ func ExchangeAuthCode(code string) error {
 
 	raw, err := util.POST(u, util.WithBody(body))
 	if err != nil {
-		log.Errorln("ExchangeAuthCode:", err)
+		logger.Errorln("ExchangeAuthCode: %v", err)
 		return err
 	}
 
@@ -112,31 +145,38 @@ func ExchangeAuthCode(code string) error {
 	}
 
 	if !result.Success {
-		log.Errorln("ExchangeAuthCode failed:%v", string(raw))
+		logger.Errorln("ExchangeAuthCode failed:%v", string(raw))
 		return errors.New("ExchangeAuthCode failed")
 	}
 
-	config.AccessToken = result.AccessToken
-	config.RefreshToken = result.RefreshToken
-	config.Expired = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	source.Set(util.Token{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	})
 
 	return nil
 }
 
 func RefreshAccessToken() error {
+	_, err := source.Refresh()
+	return err
+}
+
+func refreshAccessToken(token util.Token) (util.Token, error) {
 	var body struct {
 		RefreshToken string `json:"refresh_token"`
 		TokenUri     string `json:"token_uri"`
 	}
 
-	body.RefreshToken = config.RefreshToken
+	body.RefreshToken = token.RefreshToken
 	body.TokenUri = config.tokenuri
 	u := google + "/oauthplayground/refreshAccessToken"
 
 	raw, err := util.POST(u, util.WithBody(body))
 	if err != nil {
-		log.Errorln("RefreshAccessToken: %v", err)
-		return err
+		logger.Errorln("RefreshAccessToken: %v", err)
+		return token, err
 	}
 
 	var result struct {
@@ -148,27 +188,22 @@ func RefreshAccessToken() error {
 
 	err = json.Unmarshal(raw, &result)
 	if err != nil {
-		return err
+		return token, err
 	}
 
 	if !result.Success {
-		log.Errorln("RefreshAccessToken failed:%v", string(raw))
-		return errors.New("RefreshAccessToken failed")
+		logger.Errorln("RefreshAccessToken failed:%v", string(raw))
+		return token, errors.New("RefreshAccessToken failed")
 	}
 
-	config.AccessToken = result.AccessToken
-	config.RefreshToken = result.RefreshToken
-	config.Expired = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
-
-	log.Infoln("AccessToken:%v", config.AccessToken)
-	log.Infoln("RefreshToken:%v", config.RefreshToken)
-	log.Infoln("Expired:%v", config.Expired.Local())
-
-	data, _ := json.Marshal(config)
-	os.WriteFile("/tmp/token", data, 0666)
-	return nil
+	return util.Token{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}, nil
 }
 
+// Token 授权并刷新 token, 之后的请求在 token 过期或者 401 时自动刷新
 func Token(code string) error {
 	if code != "" {
 		err := ExchangeAuthCode(code)
@@ -177,23 +212,7 @@ func Token(code string) error {
 		}
 	}
 
-	err := RefreshAccessToken()
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		ticker := time.NewTicker(3000 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				RefreshAccessToken()
-			}
-		}
-	}()
-
-	return nil
+	return RefreshAccessToken()
 }
 
 func Download(dist string, file File) {
@@ -202,10 +221,15 @@ func Download(dist string, file File) {
 		select {
 		case <-timer.C:
 			timer.Reset(30 * time.Minute)
+			token, err := source.Token()
+			if err != nil {
+				logger.Errorln("Download token: %v", err)
+				return
+			}
 			cmd := fmt.Sprintf(`curl -C - \
 					-H 'Authorization: Bearer %v' \
 					-o %v -L 'https://www.googleapis.com/drive/v3/files/%v?alt=media&acknowledgeAbuse=True'`,
-				config.AccessToken, dist, file.ID)
+				token.AccessToken, dist, file.ID)
 			cm := exec.Command("bash", "-c", cmd)
 			cm.Stdin = os.Stdin
 			cm.Stdout = os.Stdout
@@ -237,12 +261,9 @@ func Files() (files []File, err error) {
 		"fields=files(id,name,mimeType,parents,webViewLink,createdTime,modifiedTime,shared)",
 	}
 	u := "https://www.googleapis.com/drive/v3/files?" + strings.Join(values, "&")
-	header := map[string]string{
-		"Authorization": "Bearer " + config.AccessToken,
-	}
-	raw, err := util.GET(u, util.WithHeader(header))
+	raw, err := util.GET(u, util.WithTokenSource(source))
 	if err != nil {
-		log.Infoln("%v", string(raw))
+		logger.Infoln("%v", string(raw))
 		return nil, err
 	}
 
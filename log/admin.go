@@ -0,0 +1,128 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+var adminLogger = Named("log")
+
+type levelState struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+func currentLevels() levelState {
+	state := levelState{
+		Level:   GetLevel().String(),
+		Modules: make(map[string]string),
+	}
+	for name, level := range ModuleLevels() {
+		state.Modules[name] = level.String()
+	}
+	return state
+}
+
+// LevelHandler 查看和修改日志级别:
+//
+//	GET                                   返回全局和模块的级别
+//	PUT/POST level=debug                  设置全局级别
+//	PUT/POST module=aliyundrive&level=debug 设置模块级别
+//	PUT/POST spec=info,util=warn          同 SetLevelSpec
+//	DELETE   module=aliyundrive           删除模块级别
+//
+// 参数可以在 query 或者 form 中. 修改之后返回新的级别, 并且记录一条日志.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPost:
+			err = changeLevel(r)
+		case http.MethodDelete:
+			module := r.FormValue("module")
+			if module == "" {
+				err = fmt.Errorf("module is required")
+				break
+			}
+			old := ModuleLevel(module)
+			ResetModuleLevel(module)
+			logLevelChange(module, old, ModuleLevel(module), r.RemoteAddr)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentLevels())
+	})
+}
+
+func changeLevel(r *http.Request) error {
+	if spec := r.FormValue("spec"); spec != "" {
+		old := LevelSpec()
+		if err := SetLevelSpec(spec); err != nil {
+			return err
+		}
+		announce("log level changed", "from", old, "to", LevelSpec(), "remote", r.RemoteAddr)
+		return nil
+	}
+
+	level, err := logrus.ParseLevel(r.FormValue("level"))
+	if err != nil {
+		return err
+	}
+	module := r.FormValue("module")
+	if module == "" {
+		old := GetLevel()
+		SetLevel(level)
+		logLevelChange("", old, level, r.RemoteAddr)
+		return nil
+	}
+	old := ModuleLevel(module)
+	SetModuleLevel(module, level)
+	logLevelChange(module, old, level, r.RemoteAddr)
+	return nil
+}
+
+// logLevelChange 记录级别的修改, 见 announce
+func logLevelChange(module string, from, to Level, source string) {
+	if module == "" {
+		module = "*"
+	}
+	announce("log level changed", "module", module, "from", from.String(), "to", to.String(), "source", source)
+}
+
+// announce 使用 Warn 级别记录, 级别调低到 Error 之后使用 Error 级别, 修改之后仍然可以看到
+func announce(msg string, kv ...interface{}) {
+	if adminLogger.Enabled(WarnLevel) {
+		adminLogger.Warnw(msg, kv...)
+		return
+	}
+	adminLogger.Errorw(msg, kv...)
+}
+
+// stepLevel 调整全局级别, delta > 0 输出更多的日志, 范围为 ErrorLevel 到 TraceLevel
+func stepLevel(delta int) Level {
+	old := GetLevel()
+	level := int(old) + delta
+	if level < int(ErrorLevel) {
+		level = int(ErrorLevel)
+	}
+	if level > int(TraceLevel) {
+		level = int(TraceLevel)
+	}
+	SetLevel(Level(level))
+	if Level(level) != old {
+		logLevelChange("", old, Level(level), "signal")
+	}
+	return Level(level)
+}
@@ -1,13 +1,29 @@
 package log
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestLog(t *testing.T) {
 	sub := Subscribe()
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for  v := range sub.Events() {
 			t.Log(v.Level.String(), v.Message)
 		}
@@ -20,4 +36,554 @@ func TestLog(t *testing.T) {
 	Warnln("java: %+v", 2222)
 
 	time.Sleep(time.Second*2)
+	UnSubscribe(sub)
+	<-done
+}
+
+func TestFields(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetFormatter(&JSONFormatter{})
+	defer func() {
+		SetOutput(NewTimeoutWriter(time.Second))
+		SetFormatter(&TextFormatter{DisableTimestamp: true, DisableQuote: true})
+	}()
+
+	sub := NewBaseSubscriber("fields", InfoLevel)
+	hook.AddSubscriber(sub)
+
+	logger := With("file", "a.txt", "provider", "aliyun")
+	logger.With("request_id", "r1").Infow("upload", "size", 10, "odd")
+
+	var value map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &value); err != nil {
+		t.Fatalf("json: %v %s", err, buf.Bytes())
+	}
+	if value["file"] != "a.txt" || value["request_id"] != "r1" || value["size"] != float64(10) ||
+		value[badKey] != "odd" || value["msg"] != "upload" {
+		t.Fatalf("json fields: %v", value)
+	}
+	if len(logger.Fields()) != 2 {
+		t.Fatalf("parent logger modified: %v", logger.Fields())
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Message != "upload" || event.Fields["provider"] != "aliyun" || event.Fields["size"] != 10 {
+			t.Fatalf("event fields: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event")
+	}
+}
+
+func TestNamed(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(NewTimeoutWriter(time.Second))
+	defer func() {
+		SetLevel(InfoLevel)
+		ResetModuleLevel("aliyundrive")
+		ResetModuleLevel("aliyundrive.upload")
+	}()
+
+	upload := Named("aliyundrive").Named("upload")
+	if upload.Name() != "aliyundrive.upload" {
+		t.Fatalf("name: %v", upload.Name())
+	}
+	upload.Debugln("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("debug output at info level: %s", buf.String())
+	}
+
+	if err := SetLevelSpec("warn,aliyundrive=debug"); err != nil {
+		t.Fatalf("spec: %v", err)
+	}
+	sub := NewBaseSubscriber("named")
+	hook.AddSubscriber(sub)
+
+	upload.With("file", "a.txt").Debugln("chunk %v", 1)
+	select {
+	case event := <-sub.Events():
+		if event.Logger != "aliyundrive.upload" || event.Fields["file"] != "a.txt" || len(event.Fields) != 1 {
+			t.Fatalf("event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event")
+	}
+	Named("util").Infoln("hidden")
+	if out := buf.String(); !strings.Contains(out, "chunk 1") || !strings.Contains(out, "logger=aliyundrive.upload") ||
+		strings.Contains(out, "hidden") {
+		t.Fatalf("named output: %s", out)
+	}
+
+	SetModuleLevel("aliyundrive.upload", ErrorLevel)
+	if upload.Enabled(WarnLevel) || !Named("aliyundrive").Enabled(DebugLevel) {
+		t.Fatalf("child level not applied")
+	}
+	if spec := LevelSpec(); spec != "warning,aliyundrive=debug,aliyundrive.upload=error" {
+		t.Fatalf("level spec: %v", spec)
+	}
+	if err := SetLevelSpec("util=verbose"); err == nil || ModuleLevel("util") != WarnLevel {
+		t.Fatalf("invalid spec accepted: %v", err)
+	}
+}
+
+func TestFileWriter(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "sync.log")
+	fw, err := NewFileWriter(filename, WithMaxSize(100), WithMaxBackups(2), WithCompress())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	line := []byte(strings.Repeat("x", 39) + "\n")
+	for i := 0; i < 10; i++ {
+		if _, err = fw.Write(line); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	// logrotate 移动文件之后重新打开
+	if err = os.Rename(filename, filepath.Join(dir, "moved")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err = fw.Reopen(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	fw.Write(line)
+	if err = fw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err = fw.Write(line); err == nil {
+		t.Fatalf("write after close")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var backups []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "sync-") {
+			backups = append(backups, entry.Name())
+			if !strings.HasSuffix(entry.Name(), ".log.gz") {
+				t.Fatalf("backup not compressed: %v", entry.Name())
+			}
+		}
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups: %v", backups)
+	}
+	raw, _ := os.ReadFile(filename)
+	if string(raw) != string(line) {
+		t.Fatalf("reopened file: %q", raw)
+	}
+
+	fd, _ := os.Open(filepath.Join(dir, backups[0]))
+	reader, err := gzip.NewReader(fd)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	raw, _ = io.ReadAll(reader)
+	fd.Close()
+	if len(raw) != 2*len(line) {
+		t.Fatalf("backup content: %q", raw)
+	}
+
+	var out bytes.Buffer
+	w := MultiWriter(errWriter{}, &out)
+	if _, err = w.Write(line); err == nil || out.Len() != len(line) {
+		t.Fatalf("multi writer: %v %v", err, out.Len())
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestSubscriberPolicy(t *testing.T) {
+	event := func(i int) LogEvent {
+		return LogEvent{Level: InfoLevel, Message: fmt.Sprint(i)}
+	}
+	// 等待 dispatch 取走队列中的事件
+	drain := func(s *BaseSubscriber) {
+		for i := 0; i < 100; i++ {
+			s.mu.Lock()
+			n := len(s.queue)
+			s.mu.Unlock()
+			if n == 0 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	read := func(s *BaseSubscriber, n int) (messages []string) {
+		for i := 0; i < n; i++ {
+			select {
+			case v := <-s.Events():
+				messages = append(messages, fmt.Sprintf("%v*%v", v.Message, v.Repeat+1))
+			case <-time.After(time.Second):
+				t.Fatalf("no event: %v", messages)
+			}
+		}
+		return messages
+	}
+
+	for _, tc := range []struct {
+		policy DeliveryPolicy
+		expect string
+	}{
+		{DropNewest, "1*1 2*1 3*1"},
+		{DropOldest, "1*1 4*1 5*1"},
+	} {
+		s := NewSubscriber("policy", WithPolicy(tc.policy), WithQueueSize(2))
+		s.deliver(event(1))
+		drain(s)
+		for i := 2; i <= 5; i++ {
+			s.deliver(event(i))
+		}
+		if got := strings.Join(read(s, 3), " "); got != tc.expect || s.Dropped() != 2 {
+			t.Fatalf("policy %v: %v dropped=%v", tc.policy, got, s.Dropped())
+		}
+		s.Close()
+	}
+
+	s := NewSubscriber("coalesce", WithPolicy(Coalesce), WithQueueSize(2))
+	s.deliver(event(0))
+	drain(s)
+	for _, i := range []int{1, 1, 1, 2, 3} {
+		s.deliver(event(i))
+	}
+	if got := strings.Join(read(s, 3), " "); got != "0*1 1*3 2*1" || s.Dropped() != 1 {
+		t.Fatalf("coalesce: %v dropped=%v", got, s.Dropped())
+	}
+	s.Close()
+
+	s = NewSubscriber("block", WithPolicy(Block), WithQueueSize(1), WithBlockTimeout(50*time.Millisecond))
+	s.deliver(event(1))
+	drain(s)
+	s.deliver(event(2))
+	start := time.Now()
+	s.deliver(event(3))
+	if time.Since(start) < 50*time.Millisecond || s.Dropped() != 1 {
+		t.Fatalf("block timeout: %v dropped=%v", time.Since(start), s.Dropped())
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		read(s, 1)
+	}()
+	s.deliver(event(4))
+	if got := strings.Join(read(s, 2), " "); got != "2*1 4*1" || s.Dropped() != 1 {
+		t.Fatalf("block: %v dropped=%v", got, s.Dropped())
+	}
+	s.Close()
+	s.deliver(event(5))
+	if _, ok := <-s.Events(); ok {
+		t.Fatalf("events not closed")
+	}
+
+	// 按照写日志的顺序接收
+	sub := Subscribe(WithLevels(DebugLevel), WithQueueSize(1000))
+	for i := 0; i < 200; i++ {
+		Debugln("%v", i)
+	}
+	for i := 0; i < 200; i++ {
+		if v := <-sub.Events(); v.Message != fmt.Sprint(i) {
+			t.Fatalf("order: %v != %v", v.Message, i)
+		}
+	}
+	UnSubscribe(sub)
+}
+
+func TestLevelHandler(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(NewTimeoutWriter(time.Second))
+	defer SetLevel(InfoLevel)
+
+	server := httptest.NewServer(LevelHandler())
+	defer server.Close()
+	call := func(method, query string) (int, levelState) {
+		request, _ := http.NewRequest(method, server.URL+"?"+query, nil)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer response.Body.Close()
+		var state levelState
+		json.NewDecoder(response.Body).Decode(&state)
+		return response.StatusCode, state
+	}
+
+	if code, state := call(http.MethodPut, "module=youtube&level=debug"); code != http.StatusOK || state.Modules["youtube"] != "debug" {
+		t.Fatalf("set module: %v %+v", code, state)
+	}
+	if !strings.Contains(buf.String(), "log level changed") || !strings.Contains(buf.String(), "module=youtube") {
+		t.Fatalf("change not logged: %s", buf.String())
+	}
+	SetLevel(WarnLevel)
+	buf.Reset()
+	if code, state := call(http.MethodPost, "level=error"); code != http.StatusOK || state.Level != "error" {
+		t.Fatalf("set global: %v %+v", code, state)
+	}
+	// warning -> error 之后仍然记录修改
+	if !strings.Contains(buf.String(), "log level changed") || !strings.Contains(buf.String(), "to=error") {
+		t.Fatalf("change to error not logged: %s", buf.String())
+	}
+	if code, _ := call(http.MethodPut, "level=loud"); code != http.StatusBadRequest {
+		t.Fatalf("invalid level: %v", code)
+	}
+	if code, state := call(http.MethodDelete, "module=youtube"); code != http.StatusOK || len(state.Modules["youtube"]) != 0 {
+		t.Fatalf("reset module: %v %+v", code, state)
+	}
+	if code, state := call(http.MethodGet, ""); code != http.StatusOK || state.Level != "error" {
+		t.Fatalf("get: %v %+v", code, state)
+	}
+
+	SetLevel(InfoLevel)
+	if stepLevel(1) != DebugLevel || stepLevel(1) != TraceLevel || stepLevel(1) != TraceLevel {
+		t.Fatalf("step up: %v", GetLevel())
+	}
+	SetLevel(WarnLevel)
+	if stepLevel(-1) != ErrorLevel || stepLevel(-1) != ErrorLevel {
+		t.Fatalf("step down: %v", GetLevel())
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	logger := Named("streamtest")
+	logger.Warnw("before", "job", 1)
+	logger.Warnw("other job", "job", 2)
+	logger.Debugw("debug", "job", 1)
+
+	server := httptest.NewServer(StreamHandler())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+		server.URL+"?backfill=10&logger=streamtest&field.job=1&level=info", nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("content type: %v", response.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(response.Body)
+	next := func() map[string]interface{} {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				var value map[string]interface{}
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &value)
+				return value
+			}
+		}
+	}
+	if value := next(); value["msg"] != "before" || value["logger"] != "streamtest" {
+		t.Fatalf("backfill: %v", value)
+	}
+
+	Named("other").Warnw("skip", "job", 1)
+	logger.Named("upload").Errorw("after", "job", 1)
+	if value := next(); value["msg"] != "after" || value["logger"] != "streamtest.upload" || value["level"] != "error" {
+		t.Fatalf("live: %v", value)
+	}
+
+	count := func() (n int) {
+		hook.mu.RLock()
+		defer hook.mu.RUnlock()
+		for id := range hook.subscribers {
+			if strings.HasPrefix(id, "stream-") {
+				n++
+			}
+		}
+		return n
+	}
+	cancel()
+	for i := 0; i < 100 && count() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count() != 0 {
+		t.Fatalf("subscriber not removed")
+	}
+
+	response, err = http.Get(server.URL + "?format=ndjson&backfill=1&logger=streamtest")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	line, _ := bufio.NewReader(response.Body).ReadString('\n')
+	response.Body.Close()
+	if !strings.Contains(line, `"msg":"after"`) {
+		t.Fatalf("ndjson: %v", line)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(NewTimeoutWriter(time.Second))
+	defer SetSampling(InfoLevel, SamplingPolicy{})
+	defer SetSampling(ErrorLevel, SamplingPolicy{})
+
+	SetSampling(InfoLevel, SamplingPolicy{First: 2, Thereafter: 3})
+	logger := Named("sampletest")
+	for i := 1; i <= 10; i++ {
+		logger.Infoln("batch index %v", i)
+	}
+	// 1, 2 以及之后每 3 条的一条: 5, 8
+	for _, i := range []string{"1", "2", "5", "8"} {
+		if !strings.Contains(buf.String(), "batch index "+i+" ") {
+			t.Fatalf("sampled output missing %v: %s", i, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "batch index"); n != 4 {
+		t.Fatalf("sampled count %v: %s", n, buf.String())
+	}
+
+	buf.Reset()
+	SetSampling(ErrorLevel, SamplingPolicy{Collapse: true})
+	for i := 0; i < 5; i++ {
+		logger.Errorln("connect failed")
+	}
+	Named("other").Errorln("connect failed")
+	logger.Errorln("done")
+	out := buf.String()
+	if strings.Count(out, "connect failed") != 2 || !strings.Contains(out, "last message repeated 4 times") ||
+		strings.Index(out, "repeated") > strings.Index(out, "done") {
+		t.Fatalf("collapse: %s", out)
+	}
+
+	// 之后没有其他消息时, 超过 Interval 或者 Flush 时输出重复数量
+	SetSampling(ErrorLevel, SamplingPolicy{Collapse: true, Interval: 100 * time.Millisecond})
+	var mu sync.Mutex
+	var events []LogEvent
+	sub := NewBaseSubscriber("collapse", ErrorLevel)
+	hook.AddSubscriber(sub)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range sub.Events() {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}()
+	repeated := func() (val []interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range events {
+			if event.Logger == "sampletest" && event.Fields["repeated"] != nil {
+				val = append(val, event.Fields["repeated"])
+			}
+		}
+		return val
+	}
+	for i := 0; i < 3; i++ {
+		logger.Errorln("timeout")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if val := repeated(); len(val) != 1 || val[0] != 2 {
+		t.Fatalf("expired repeat: %v", val)
+	}
+	logger.Errorln("timeout")
+	logger.Errorln("timeout")
+	Flush()
+	time.Sleep(50 * time.Millisecond)
+	if val := repeated(); len(val) != 2 || val[1] != 1 {
+		t.Fatalf("flushed repeat: %v", val)
+	}
+	UnSubscribe(sub)
+	<-done
+
+	s := &sampler{policy: SamplingPolicy{First: 1, Interval: time.Second}, counters: map[string]*sampleCounter{}}
+	now := time.Now()
+	if ok, _ := s.check("a", "k", "m", now); !ok {
+		t.Fatalf("first dropped")
+	}
+	if ok, _ := s.check("a", "k", "m", now); ok {
+		t.Fatalf("second not dropped")
+	}
+	if ok, _ := s.check("a", "k", "m", now.Add(time.Second)); !ok {
+		t.Fatalf("new interval dropped")
+	}
+}
+
+func TestCaller(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetFormatter(&JSONFormatter{})
+	SetReportCaller(true)
+	SetReportStack(true)
+	defer func() {
+		SetReportCaller(false)
+		SetReportStack(false)
+		SetOutput(NewTimeoutWriter(time.Second))
+		SetFormatter(&TextFormatter{DisableTimestamp: true, DisableQuote: true})
+	}()
+
+	sub := NewSubscriber("caller")
+	hook.AddSubscriber(sub)
+	defer UnSubscribe(sub)
+
+	logger := Named("callertest").With("job", 1)
+	_, _, line, _ := runtime.Caller(0)
+	Infoln("package")
+	logger.Warnw("method")
+	logger.Errorln("error")
+
+	for i, message := range []string{"package", "method", "error"} {
+		event := <-sub.Events()
+		caller := fmt.Sprintf("log/all_test.go:%d", line+1+i)
+		if event.Message != message || event.Caller != caller || event.Function != "github.com/tiechui1994/tool/log.TestCaller" {
+			t.Fatalf("caller: %+v expect %v", event, caller)
+		}
+		if (message == "error") != (event.Stack != "") {
+			t.Fatalf("stack: %+v", event)
+		}
+		if message == "error" && !strings.HasPrefix(event.Stack, "github.com/tiechui1994/tool/log.TestCaller\n") {
+			t.Fatalf("stack not start from caller: %v", event.Stack)
+		}
+		if _, ok := event.Fields[callerKey]; ok {
+			t.Fatalf("caller in fields: %+v", event)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var value map[string]interface{}
+	json.Unmarshal([]byte(lines[len(lines)-1]), &value)
+	if value["caller"] != fmt.Sprintf("log/all_test.go:%d", line+3) || !strings.Contains(fmt.Sprint(value["stack"]), "TestCaller") {
+		t.Fatalf("json: %v", value)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != std || FromContext(nil) != std {
+		t.Fatalf("default logger")
+	}
+
+	logger := Named("job").With("job", "j1")
+	ctx := WithContext(context.Background(), logger)
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if FromContext(child) != logger {
+		t.Fatalf("logger not found in child context")
+	}
+
+	sub := NewBaseSubscriber("context", InfoLevel)
+	hook.AddSubscriber(sub)
+	defer UnSubscribe(sub)
+	FromContext(child).Infow("download", "file", "a.txt")
+	select {
+	case event := <-sub.Events():
+		if event.Logger != "job" || event.Fields["job"] != "j1" || event.Fields["file"] != "a.txt" {
+			t.Fatalf("event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event")
+	}
 }
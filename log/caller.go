@@ -0,0 +1,114 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// 调用位置和调用栈的字段名, 由 TextFormatter 和 JSONFormatter 输出, 在 LogEvent 中为 Caller, Function, Stack
+const (
+	callerKey   = "caller"
+	functionKey = "func"
+	stackKey    = "stack"
+)
+
+const maxStackDepth = 32
+
+var (
+	reportCaller int32
+	reportStack  int32
+)
+
+// SetReportCaller 记录调用位置(文件:行号和函数), 默认关闭
+func SetReportCaller(enabled bool) {
+	atomic.StoreInt32(&reportCaller, boolToInt32(enabled))
+}
+
+// SetReportStack Error 和 Fatal 级别记录调用栈, 默认关闭
+func SetReportStack(enabled bool) {
+	atomic.StoreInt32(&reportStack, boolToInt32(enabled))
+}
+
+func boolToInt32(v bool) int32 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func stackEnabled(level Level) bool {
+	return level <= ErrorLevel && atomic.LoadInt32(&reportStack) == 1
+}
+
+func captureEnabled(level Level) bool {
+	return atomic.LoadInt32(&reportCaller) == 1 || stackEnabled(level)
+}
+
+// callers 返回调用栈, skip 为跳过 callers 的调用者之上的层数, 0 表示从 callers 的调用者开始
+func callers(skip int) []runtime.Frame {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var val []runtime.Frame
+	for {
+		frame, more := frames.Next()
+		val = append(val, frame)
+		if !more {
+			return val
+		}
+	}
+}
+
+// trimCallers 删除 pc 所在函数之前的调用栈(例如 slog 内部的调用). pc 处可能有内联的函数, 使用最外层的函数.
+func trimCallers(frames []runtime.Frame, pc uintptr) []runtime.Frame {
+	if pc == 0 {
+		return frames
+	}
+	var target runtime.Frame
+	pcFrames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		frame, more := pcFrames.Next()
+		target = frame
+		if !more {
+			break
+		}
+	}
+	for i, frame := range frames {
+		if frame.Function == target.Function {
+			return frames[i:]
+		}
+	}
+	return frames
+}
+
+// shortFile 保留最后一级目录, 例如 "aliyundrive/aliyundrive.go"
+func shortFile(file string) string {
+	idx := strings.LastIndexByte(file, '/')
+	if idx > 0 {
+		if i := strings.LastIndexByte(file[:idx], '/'); i >= 0 {
+			return file[i+1:]
+		}
+	}
+	return file
+}
+
+func addCaller(fields Fields, frames []runtime.Frame, level Level) {
+	if len(frames) == 0 {
+		return
+	}
+	if atomic.LoadInt32(&reportCaller) == 1 {
+		fields[callerKey] = fmt.Sprintf("%v:%v", shortFile(frames[0].File), frames[0].Line)
+		fields[functionKey] = frames[0].Function
+	}
+	if !stackEnabled(level) {
+		return
+	}
+
+	var stack strings.Builder
+	for _, frame := range frames {
+		fmt.Fprintf(&stack, "%v\n\t%v:%v\n", frame.Function, frame.File, frame.Line)
+	}
+	fields[stackKey] = stack.String()
+}
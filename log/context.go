@@ -0,0 +1,26 @@
+package log
+
+import (
+	"context"
+)
+
+type contextKey struct{}
+
+// WithContext 返回带有 logger 的 ctx, 用于在调用链中传递请求 ID, 任务 ID 等字段
+func WithContext(ctx context.Context, logger *Logger) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext 返回 ctx 中的 Logger, 没有时返回全局 Logger
+func FromContext(ctx context.Context) *Logger {
+	if ctx == nil {
+		return std
+	}
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok && logger != nil {
+		return logger
+	}
+	return std
+}
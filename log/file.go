@@ -0,0 +1,379 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "20060102T150405.000"
+	compressSuffix   = ".gz"
+
+	defaultMaxSize = 100 * 1024 * 1024
+)
+
+type fileConfig struct {
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+	signal     bool
+}
+
+type FileOption func(*fileConfig)
+
+// WithMaxSize 文件超过 size 字节时切分, 默认 100M, size <= 0 表示不按照大小切分
+func WithMaxSize(size int64) FileOption {
+	return func(config *fileConfig) {
+		config.maxSize = size
+	}
+}
+
+// WithRotateInterval 按照时间切分, 切分时间点为 interval 的整数倍(UTC), 例如 24h 在每天 UTC 0 点切分
+func WithRotateInterval(interval time.Duration) FileOption {
+	return func(config *fileConfig) {
+		config.interval = interval
+	}
+}
+
+// WithMaxBackups 最多保留的备份文件数量, 0 表示不限制
+func WithMaxBackups(n int) FileOption {
+	return func(config *fileConfig) {
+		config.maxBackups = n
+	}
+}
+
+// WithMaxAge 备份文件保留的时间, 0 表示不限制
+func WithMaxAge(age time.Duration) FileOption {
+	return func(config *fileConfig) {
+		config.maxAge = age
+	}
+}
+
+// WithCompress 使用 gzip 压缩备份文件
+func WithCompress() FileOption {
+	return func(config *fileConfig) {
+		config.compress = true
+	}
+}
+
+// WithReopenSignal 收到 SIGHUP 时重新打开文件, 配合外部的 logrotate 使用(windows 下无效)
+func WithReopenSignal() FileOption {
+	return func(config *fileConfig) {
+		config.signal = true
+	}
+}
+
+// FileWriter 支持切分的日志文件. 备份文件名为 "name-20060102T150405.000.ext", 与日志文件在同一个目录.
+// 与标准输出一起使用:
+//
+//	fw, _ := log.NewFileWriter("/var/log/sync.log", log.WithMaxBackups(7))
+//	log.SetOutput(log.MultiWriter(log.NewTimeoutWriter(time.Second), fw))
+type FileWriter struct {
+	filename string
+	config   fileConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	next   time.Time // 下一次按照时间切分的时间点
+	closed bool
+
+	mill     sync.Mutex // 压缩和清理备份文件
+	wg       sync.WaitGroup
+	stopSign func()
+}
+
+func NewFileWriter(filename string, opts ...FileOption) (*FileWriter, error) {
+	config := fileConfig{maxSize: defaultMaxSize}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	w := &FileWriter{filename: filename, config: config}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	if config.signal {
+		w.stopSign = notifyReopen(w)
+	}
+	return w, nil
+}
+
+// open 以追加的方式打开文件, 调用时需要持有 mu
+func (w *FileWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	if w.config.interval > 0 {
+		// 已经存在的文件使用修改时间, 重启之后仍然按照原来的周期切分
+		start := time.Now()
+		if w.size > 0 {
+			start = info.ModTime()
+		}
+		w.next = start.Truncate(w.config.interval).Add(w.config.interval)
+	}
+	return nil
+}
+
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.file == nil {
+		// 上一次打开失败, 重试
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	expired := w.config.interval > 0 && !time.Now().Before(w.next)
+	full := w.config.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.config.maxSize
+	if expired || full {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate 立即切分日志文件
+func (w *FileWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+func (w *FileWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	if _, err := os.Stat(w.filename); err == nil {
+		if err = os.Rename(w.filename, w.backupName(time.Now())); err != nil {
+			return err
+		}
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.millBackups()
+	}()
+	return nil
+}
+
+// Reopen 关闭并重新打开文件, 用于外部程序(logrotate)移动文件之后
+func (w *FileWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// Close 关闭文件, 等待备份文件处理完成
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	if w.stopSign != nil {
+		w.stopSign()
+	}
+	w.wg.Wait()
+	return err
+}
+
+func (w *FileWriter) prefixAndExt() (prefix, ext string) {
+	base := filepath.Base(w.filename)
+	ext = filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-", ext
+}
+
+func (w *FileWriter) backupName(t time.Time) string {
+	prefix, ext := w.prefixAndExt()
+	name := filepath.Join(filepath.Dir(w.filename), prefix+t.Format(backupTimeFormat)+ext)
+	// 同一毫秒内多次切分
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err = os.Stat(name + compressSuffix); os.IsNotExist(err) {
+				return name
+			}
+		}
+		name = filepath.Join(filepath.Dir(w.filename), fmt.Sprintf("%v%v.%d%v", prefix, t.Format(backupTimeFormat), i, ext))
+	}
+}
+
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// backups 返回所有的备份文件, 按照时间从新到旧排序
+func (w *FileWriter) backups() ([]backupFile, error) {
+	entries, err := os.ReadDir(filepath.Dir(w.filename))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, ext := w.prefixAndExt()
+	var files []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(name, compressSuffix), ext)
+		value = strings.TrimPrefix(value, prefix)
+		if len(value) < len(backupTimeFormat) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, value[:len(backupTimeFormat)], time.Local)
+		if err != nil {
+			continue
+		}
+		files = append(files, backupFile{path: filepath.Join(filepath.Dir(w.filename), name), time: t})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].time.Equal(files[j].time) {
+			return files[i].path > files[j].path
+		}
+		return files[i].time.After(files[j].time)
+	})
+	return files, nil
+}
+
+// millBackups 删除超出数量和时间的备份文件, 压缩未压缩的备份文件
+func (w *FileWriter) millBackups() {
+	w.mill.Lock()
+	defer w.mill.Unlock()
+
+	files, err := w.backups()
+	if err != nil {
+		return
+	}
+
+	var keep []backupFile
+	for i, file := range files {
+		expired := w.config.maxAge > 0 && time.Since(file.time) > w.config.maxAge
+		if (w.config.maxBackups > 0 && i >= w.config.maxBackups) || expired {
+			os.Remove(file.path)
+			continue
+		}
+		keep = append(keep, file)
+	}
+
+	if !w.config.compress {
+		return
+	}
+	for _, file := range keep {
+		if strings.HasSuffix(file.path, compressSuffix) {
+			continue
+		}
+		if err := compressFile(file.path); err != nil {
+			// 不能写日志, 避免递归
+			fmt.Fprintf(os.Stderr, "compress log file %v: %v\n", file.path, err)
+		}
+	}
+}
+
+func compressFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := src + compressSuffix + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, src+compressSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
+
+type multiWriter struct {
+	writers []io.Writer
+}
+
+// MultiWriter 与 io.MultiWriter 类似, 但是一个 writer 失败时仍然写入其他的 writer, 返回第一个错误
+func MultiWriter(writers ...io.Writer) io.Writer {
+	return &multiWriter{writers: writers}
+}
+
+func (m *multiWriter) Write(p []byte) (int, error) {
+	var err error
+	for _, w := range m.writers {
+		if _, e := w.Write(p); e != nil && err == nil {
+			err = e
+		}
+	}
+	return len(p), err
+}
@@ -17,3 +17,5 @@ const (
 type TextFormatter = logrus.TextFormatter
 
 type JSONFormatter = logrus.JSONFormatter
+
+type Hook = logrus.Hook
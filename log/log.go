@@ -16,47 +16,45 @@ var (
 
 func init() {
 	logrus.SetOutput(NewTimeoutWriter(time.Second))
-	logrus.SetLevel(InfoLevel)
+	logrus.SetLevel(TraceLevel)
 	logrus.SetFormatter(&logrus.TextFormatter{
 		DisableTimestamp: true,
 		DisableQuote:     true,
 	})
+	logrus.RegisterExitHandler(Flush)
+	initLevelEnv()
 }
 
 func Traceln(format string, args ...interface{}) {
-	sprint(TraceLevel, fmt.Sprintf(format, args...))
-	logrus.Tracef(format, args...)
+	std.output(TraceLevel, format, fmt.Sprintf(format, args...), nil)
 }
 
 func Debugln(format string, args ...interface{}) {
-	sprint(DebugLevel, fmt.Sprintf(format, args...))
-	logrus.Debugf(format, args...)
+	std.output(DebugLevel, format, fmt.Sprintf(format, args...), nil)
 }
 
 func Infoln(format string, args ...interface{}) {
-	sprint(InfoLevel, fmt.Sprintf(format, args...))
-	logrus.Infof(format, args...)
+	std.output(InfoLevel, format, fmt.Sprintf(format, args...), nil)
 }
 
 func Warnln(format string, args ...interface{}) {
-	sprint(WarnLevel, fmt.Sprintf(format, args...))
-	logrus.Warnf(format, args...)
+	std.output(WarnLevel, format, fmt.Sprintf(format, args...), nil)
 }
 
 func Errorln(format string, args ...interface{}) {
-	sprint(ErrorLevel, fmt.Sprintf(format, args...))
-	logrus.Errorf(format, args...)
+	std.output(ErrorLevel, format, fmt.Sprintf(format, args...), nil)
 }
 
 func Fatalln(format string, args ...interface{}) {
-	sprint(FatalLevel, fmt.Sprintf(format, args...))
-	logrus.Fatalf(format, args...)
+	std.output(FatalLevel, format, fmt.Sprintf(format, args...), nil)
 }
 
-func sprint(level Level, message string) {
+func sprint(level Level, message string, fields Fields) {
 	hook.Fire(&logrus.Entry{
 		Level:   level,
+		Time:    time.Now(),
 		Message: message,
+		Data:    fields,
 	})
 }
 
@@ -64,21 +62,28 @@ func SetOutput(out io.Writer) {
 	logrus.SetOutput(out)
 }
 
+// AddHook 添加 logrus hook, 只接收 Logger 级别允许输出的日志
+func AddHook(h Hook) {
+	logrus.AddHook(h)
+}
+
 func SetFormatter(f logrus.Formatter) {
 	logrus.SetFormatter(f)
 }
 
+// GetLevel 返回全局日志级别, 模块的级别使用 ModuleLevel
 func GetLevel() logrus.Level {
-	return logrus.GetLevel()
+	return levels.getGlobal()
 }
 
 func SetLevel(newLevel logrus.Level) {
-	logrus.SetLevel(newLevel)
+	levels.setGlobal(newLevel)
 }
 
-func Subscribe() Subscriber {
+// Subscribe 添加订阅者, 默认接收所有级别, 队列满时丢弃新的事件
+func Subscribe(opts ...SubscribeOption) Subscriber {
 	id := time.Now().Format(time.RFC3339Nano)
-	sub := NewBaseSubscriber(id)
+	sub := NewSubscriber(id, opts...)
 	hook.AddSubscriber(sub)
 	return sub
 }
@@ -0,0 +1,195 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Fields = logrus.Fields
+
+// badKey 奇数个参数时最后一个值使用的 key
+const badKey = "!BADKEY"
+
+// Logger 带有名称和字段的 logger, 字段会输出到 logrus 并且出现在 LogEvent.Fields 中.
+// Logger 是不可变的, With 和 Named 返回新的 Logger, 可以在多个 goroutine 中使用.
+type Logger struct {
+	name   string
+	fields Fields
+}
+
+var std = &Logger{}
+
+// With 返回带有字段的 Logger, 参数为 key, value 交替出现, 也可以是 Fields
+func With(kv ...interface{}) *Logger {
+	return std.With(kv...)
+}
+
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := l.merge(kv)
+	if len(fields) == 0 {
+		return l
+	}
+	return &Logger{name: l.name, fields: fields}
+}
+
+// Fields 返回 Logger 的字段(副本)
+func (l *Logger) Fields() Fields {
+	return l.merge(nil)
+}
+
+// merge 合并字段, 后面的值覆盖前面的值
+func (l *Logger) merge(kv []interface{}) Fields {
+	if len(l.fields) == 0 && len(kv) == 0 {
+		return nil
+	}
+	fields := make(Fields, len(l.fields)+len(kv)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for i := 0; i < len(kv); i++ {
+		switch key := kv[i].(type) {
+		case Fields:
+			for k, v := range key {
+				fields[k] = v
+			}
+		case map[string]interface{}:
+			for k, v := range key {
+				fields[k] = v
+			}
+		case string:
+			if i+1 == len(kv) {
+				fields[badKey] = key
+				continue
+			}
+			fields[key] = kv[i+1]
+			i++
+		default:
+			if i+1 == len(kv) {
+				fields[badKey] = key
+				continue
+			}
+			fields[fmt.Sprint(key)] = kv[i+1]
+			i++
+		}
+	}
+	return fields
+}
+
+// output 经过采样之后输出, key 为采样使用的消息模板(格式化之前的 format 或者 msg).
+// output 只能在导出的日志方法中直接调用, 否则调用位置不正确.
+func (l *Logger) output(level Level, key, message string, fields Fields) {
+	var frames []runtime.Frame
+	if captureEnabled(level) {
+		frames = callers(2)
+	}
+	l.write(frames, level, key, message, fields)
+}
+
+// write frames 为调用栈(第一个为调用位置), 没有开启调用位置和调用栈时为 nil
+func (l *Logger) write(frames []runtime.Frame, level Level, key, message string, fields Fields) {
+	ok, repeated := sample(level, l.name, key, message)
+	if repeated > 0 {
+		l.emit(frames, level, repeatedMessage(repeated), Fields{"repeated": repeated})
+	}
+	if ok {
+		l.emit(frames, level, message, fields)
+	}
+	if level == FatalLevel {
+		logrus.Exit(1)
+	}
+}
+
+func (l *Logger) emit(frames []runtime.Frame, level Level, message string, fields Fields) {
+	if l.name != "" || len(frames) > 0 {
+		extra := make(Fields, len(fields)+4)
+		for k, v := range fields {
+			extra[k] = v
+		}
+		if l.name != "" {
+			extra[loggerKey] = l.name
+		}
+		addCaller(extra, frames, level)
+		fields = extra
+	}
+	sprint(level, message, fields)
+
+	// logrus 的级别为 TraceLevel, 由 Logger 的级别决定是否输出
+	if l.Enabled(level) {
+		logrus.WithFields(fields).Log(level, message)
+	}
+}
+
+func (l *Logger) Traceln(format string, args ...interface{}) {
+	l.output(TraceLevel, format, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *Logger) Debugln(format string, args ...interface{}) {
+	l.output(DebugLevel, format, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *Logger) Infoln(format string, args ...interface{}) {
+	l.output(InfoLevel, format, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *Logger) Warnln(format string, args ...interface{}) {
+	l.output(WarnLevel, format, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *Logger) Errorln(format string, args ...interface{}) {
+	l.output(ErrorLevel, format, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *Logger) Fatalln(format string, args ...interface{}) {
+	l.output(FatalLevel, format, fmt.Sprintf(format, args...), l.fields)
+}
+
+// Tracew 输出消息和字段, kv 为 key, value 交替出现
+func (l *Logger) Tracew(msg string, kv ...interface{}) {
+	l.output(TraceLevel, msg, msg, l.merge(kv))
+}
+
+func (l *Logger) Debugw(msg string, kv ...interface{}) {
+	l.output(DebugLevel, msg, msg, l.merge(kv))
+}
+
+func (l *Logger) Infow(msg string, kv ...interface{}) {
+	l.output(InfoLevel, msg, msg, l.merge(kv))
+}
+
+func (l *Logger) Warnw(msg string, kv ...interface{}) {
+	l.output(WarnLevel, msg, msg, l.merge(kv))
+}
+
+func (l *Logger) Errorw(msg string, kv ...interface{}) {
+	l.output(ErrorLevel, msg, msg, l.merge(kv))
+}
+
+func (l *Logger) Fatalw(msg string, kv ...interface{}) {
+	l.output(FatalLevel, msg, msg, l.merge(kv))
+}
+
+func Tracew(msg string, kv ...interface{}) {
+	std.output(TraceLevel, msg, msg, std.merge(kv))
+}
+
+func Debugw(msg string, kv ...interface{}) {
+	std.output(DebugLevel, msg, msg, std.merge(kv))
+}
+
+func Infow(msg string, kv ...interface{}) {
+	std.output(InfoLevel, msg, msg, std.merge(kv))
+}
+
+func Warnw(msg string, kv ...interface{}) {
+	std.output(WarnLevel, msg, msg, std.merge(kv))
+}
+
+func Errorw(msg string, kv ...interface{}) {
+	std.output(ErrorLevel, msg, msg, std.merge(kv))
+}
+
+func Fatalw(msg string, kv ...interface{}) {
+	std.output(FatalLevel, msg, msg, std.merge(kv))
+}
@@ -0,0 +1,173 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LevelEnv 环境变量, 设置全局和模块的日志级别, 例如:
+//
+//	TOOL_LOG="info,aliyundrive=debug,util=warn"
+const LevelEnv = "TOOL_LOG"
+
+// loggerKey Logger 名称在 logrus 输出中的字段名
+const loggerKey = "logger"
+
+// levelTable 全局和模块的日志级别. 模块名称使用 "." 分隔, 没有设置级别的模块使用上一级模块的级别,
+// 例如 "aliyundrive.upload" 依次查找 "aliyundrive.upload", "aliyundrive" 和全局级别.
+type levelTable struct {
+	mu      sync.RWMutex
+	global  Level
+	modules map[string]Level
+}
+
+var levels = &levelTable{
+	global:  InfoLevel,
+	modules: make(map[string]Level),
+}
+
+func (t *levelTable) level(name string) Level {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for name != "" {
+		if level, ok := t.modules[name]; ok {
+			return level
+		}
+		idx := strings.LastIndexByte(name, '.')
+		if idx < 0 {
+			break
+		}
+		name = name[:idx]
+	}
+	return t.global
+}
+
+func (t *levelTable) setGlobal(level Level) {
+	t.mu.Lock()
+	t.global = level
+	t.mu.Unlock()
+}
+
+func (t *levelTable) getGlobal() Level {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.global
+}
+
+// Named 返回名称为 name 的 Logger, 使用 SetModuleLevel 设置的级别
+func Named(name string) *Logger {
+	return std.Named(name)
+}
+
+// Named 返回子模块的 Logger, 名称为 "parent.name", 保留已有的字段
+func (l *Logger) Named(name string) *Logger {
+	name = strings.Trim(name, ".")
+	if name == "" {
+		return l
+	}
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return &Logger{name: name, fields: l.fields}
+}
+
+func (l *Logger) Name() string {
+	return l.name
+}
+
+// Enabled 判断级别为 level 的日志是否会输出
+func (l *Logger) Enabled(level Level) bool {
+	return level <= levels.level(l.name)
+}
+
+// SetModuleLevel 设置模块(包括子模块)的日志级别
+func SetModuleLevel(name string, level Level) {
+	levels.mu.Lock()
+	levels.modules[name] = level
+	levels.mu.Unlock()
+}
+
+// ResetModuleLevel 删除模块的日志级别, 之后使用上一级模块的级别
+func ResetModuleLevel(name string) {
+	levels.mu.Lock()
+	delete(levels.modules, name)
+	levels.mu.Unlock()
+}
+
+// ModuleLevel 返回模块实际使用的日志级别
+func ModuleLevel(name string) Level {
+	return levels.level(name)
+}
+
+// ModuleLevels 返回设置过级别的模块
+func ModuleLevels() map[string]Level {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+	val := make(map[string]Level, len(levels.modules))
+	for k, v := range levels.modules {
+		val[k] = v
+	}
+	return val
+}
+
+// SetLevelSpec 解析 "level,module=level,..." 并设置日志级别, 没有模块名称(或者为 "*")时设置全局级别.
+// 解析失败时不修改任何级别.
+func SetLevelSpec(spec string) error {
+	global := Level(0)
+	hasGlobal := false
+	modules := make(map[string]Level)
+	for _, item := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	}) {
+		name, value := "", item
+		if idx := strings.IndexByte(item, '='); idx >= 0 {
+			name, value = strings.TrimSpace(item[:idx]), item[idx+1:]
+		}
+		level, err := logrus.ParseLevel(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid log level %q: %w", item, err)
+		}
+		if name == "" || name == "*" {
+			global, hasGlobal = level, true
+			continue
+		}
+		modules[name] = level
+	}
+
+	if hasGlobal {
+		levels.setGlobal(global)
+	}
+	for name, level := range modules {
+		SetModuleLevel(name, level)
+	}
+	return nil
+}
+
+// LevelSpec 返回当前的级别设置, 格式同 SetLevelSpec
+func LevelSpec() string {
+	modules := ModuleLevels()
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := []string{levels.getGlobal().String()}
+	for _, name := range names {
+		values = append(values, name+"="+modules[name].String())
+	}
+	return strings.Join(values, ",")
+}
+
+func initLevelEnv() {
+	if spec := os.Getenv(LevelEnv); spec != "" {
+		if err := SetLevelSpec(spec); err != nil {
+			Warnln("%v: %v", LevelEnv, err)
+		}
+	}
+}
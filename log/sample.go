@@ -0,0 +1,202 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSampleInterval = time.Minute
+	maxSampleKeys         = 10000
+)
+
+// SamplingPolicy 一个级别的采样策略. 采样同时作用于输出和订阅者.
+//
+// 采样按照 Logger 名称和消息模板(Xxxln 的 format, Xxxw 的 msg)分组: 每个 Interval 内前 First 条全部输出,
+// 之后每 Thereafter 条输出一条(Thereafter 为 0 时全部丢弃). First 为 0 时不采样.
+//
+// Collapse 为 true 时, 同一个 Logger 连续输出相同的消息(格式化之后)只保留第一条, 在下一条不同的消息之前,
+// 第一条消息之后超过 Interval 时或者调用 Flush 时输出 "last message repeated N times".
+type SamplingPolicy struct {
+	First      int
+	Thereafter int
+	Interval   time.Duration
+	Collapse   bool
+}
+
+type sampleCounter struct {
+	start time.Time
+	count int
+}
+
+type collapseState struct {
+	message string
+	since   time.Time
+	count   int
+	timer   *time.Timer // 超过 Interval 时输出被合并的数量
+}
+
+type sampler struct {
+	level  Level
+	policy SamplingPolicy
+
+	mu       sync.Mutex
+	counters map[string]*sampleCounter
+	last     map[string]*collapseState
+}
+
+var samplers struct {
+	sync.RWMutex
+	levels [TraceLevel + 1]*sampler
+}
+
+// SetSampling 设置级别的采样策略, 零值表示关闭. Fatal 和 Panic 级别不采样.
+func SetSampling(level Level, policy SamplingPolicy) {
+	if level <= FatalLevel || level > TraceLevel {
+		return
+	}
+	if policy.Interval <= 0 {
+		policy.Interval = defaultSampleInterval
+	}
+
+	var s *sampler
+	if policy.First > 0 || policy.Collapse {
+		s = &sampler{
+			level:    level,
+			policy:   policy,
+			counters: make(map[string]*sampleCounter),
+			last:     make(map[string]*collapseState),
+		}
+	}
+	samplers.Lock()
+	old := samplers.levels[level]
+	samplers.levels[level] = s
+	samplers.Unlock()
+
+	if old != nil {
+		old.flush()
+	}
+}
+
+// Flush 输出所有被合并的重复消息的数量, 程序退出之前调用. Fatal 退出时自动调用.
+func Flush() {
+	samplers.RLock()
+	list := samplers.levels
+	samplers.RUnlock()
+	for _, s := range list {
+		if s != nil {
+			s.flush()
+		}
+	}
+}
+
+func repeatedMessage(repeated int) string {
+	return fmt.Sprintf("last message repeated %d times", repeated)
+}
+
+// flush 输出并清除所有等待输出的重复数量
+func (s *sampler) flush() {
+	pending := make(map[string]int)
+	s.mu.Lock()
+	for name, state := range s.last {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		if state.count > 0 {
+			pending[name] = state.count
+		}
+	}
+	s.last = make(map[string]*collapseState)
+	s.mu.Unlock()
+
+	for name, repeated := range pending {
+		s.emit(name, repeated)
+	}
+}
+
+// expire 第一条消息之后超过 Interval 时输出重复数量, 之后相同的消息重新输出
+func (s *sampler) expire(name string, state *collapseState) {
+	s.mu.Lock()
+	if s.last[name] != state || state.count == 0 {
+		s.mu.Unlock()
+		return
+	}
+	repeated := state.count
+	delete(s.last, name)
+	s.mu.Unlock()
+
+	s.emit(name, repeated)
+}
+
+func (s *sampler) emit(name string, repeated int) {
+	logger := &Logger{name: name}
+	logger.emit(nil, s.level, repeatedMessage(repeated), Fields{"repeated": repeated})
+}
+
+// sample 返回消息是否输出, 以及之前被合并的相同消息数量(需要先输出 "last message repeated")
+func sample(level Level, name, key, message string) (ok bool, repeated int) {
+	if level > TraceLevel {
+		return true, 0
+	}
+	samplers.RLock()
+	s := samplers.levels[level]
+	samplers.RUnlock()
+	if s == nil {
+		return true, 0
+	}
+	return s.check(name, key, message, time.Now())
+}
+
+func (s *sampler) check(name, key, message string, now time.Time) (ok bool, repeated int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.policy.Collapse {
+		state := s.last[name]
+		if state != nil && state.message == message && now.Sub(state.since) < s.policy.Interval {
+			state.count++
+			if state.timer == nil {
+				state.timer = time.AfterFunc(s.policy.Interval-now.Sub(state.since), func() {
+					s.expire(name, state)
+				})
+			}
+			return false, 0
+		}
+		if state != nil {
+			repeated = state.count
+			if state.timer != nil {
+				state.timer.Stop()
+			}
+		}
+		if state == nil && len(s.last) >= maxSampleKeys {
+			s.last = make(map[string]*collapseState)
+		}
+		s.last[name] = &collapseState{message: message, since: now}
+	}
+
+	if s.policy.First <= 0 {
+		return true, repeated
+	}
+	key = name + "\x00" + key
+	counter := s.counters[key]
+	if counter == nil {
+		if len(s.counters) >= maxSampleKeys {
+			s.counters = make(map[string]*sampleCounter)
+		}
+		counter = &sampleCounter{start: now}
+		s.counters[key] = counter
+	}
+	if now.Sub(counter.start) >= s.policy.Interval {
+		counter.start, counter.count = now, 0
+	}
+	counter.count++
+
+	if counter.count <= s.policy.First {
+		return true, repeated
+	}
+	if s.policy.Thereafter > 0 && (counter.count-s.policy.First)%s.policy.Thereafter == 0 {
+		return true, repeated
+	}
+	return false, repeated
+}
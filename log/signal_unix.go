@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReopen 收到 SIGHUP 时重新打开文件, 返回的函数停止监听
+func notifyReopen(w *FileWriter) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := w.Reopen(); err != nil && err != os.ErrClosed {
+					fmt.Fprintf(os.Stderr, "reopen log file %v: %v\n", w.filename, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// NotifyLevelSignals 收到 SIGUSR1 时输出更多的日志(info -> debug -> trace), 收到 SIGUSR2 时输出更少的日志
+// (info -> warning -> error), 返回的函数停止监听
+func NotifyLevelSignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGUSR1 {
+					stepLevel(1)
+				} else {
+					stepLevel(-1)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
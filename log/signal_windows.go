@@ -0,0 +1,10 @@
+package log
+
+func notifyReopen(w *FileWriter) func() {
+	return nil
+}
+
+// NotifyLevelSignals windows 不支持 SIGUSR1 和 SIGUSR2
+func NotifyLevelSignals() (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,476 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tiechui1994/tool/log"
+	"github.com/tiechui1994/tool/util"
+)
+
+const (
+	spillPrefix = "spill-"
+	spillSuffix = ".ndjson"
+)
+
+type shipperConfig struct {
+	client     *util.EmbedClient
+	header     map[string]string
+	batchSize  int
+	interval   time.Duration
+	bufferSize int
+	retry      uint
+	timeout    time.Duration
+	spillDir   string
+	spillLimit int64
+}
+
+type ShipperOption func(*shipperConfig)
+
+// WithShipperClient 发送请求使用的 client, 默认使用 util.NewClient()
+func WithShipperClient(client *util.EmbedClient) ShipperOption {
+	return func(config *shipperConfig) {
+		config.client = client
+	}
+}
+
+// WithShipperHeader 请求的 header, 例如认证信息
+func WithShipperHeader(header map[string]string) ShipperOption {
+	return func(config *shipperConfig) {
+		config.header = header
+	}
+}
+
+// WithBatch 每批最多 size 条日志, 最长等待 interval 发送一次, 默认 100 条, 5s
+func WithBatch(size int, interval time.Duration) ShipperOption {
+	return func(config *shipperConfig) {
+		if size > 0 {
+			config.batchSize = size
+		}
+		if interval > 0 {
+			config.interval = interval
+		}
+	}
+}
+
+// WithBufferSize 内存中最多缓存的日志数量, 默认 10000, 超过之后写入 spill 目录或者丢弃
+func WithBufferSize(size int) ShipperOption {
+	return func(config *shipperConfig) {
+		if size > 0 {
+			config.bufferSize = size
+		}
+	}
+}
+
+// WithShipperRetry 每批日志的重试次数, 默认 3
+func WithShipperRetry(retry uint, timeout time.Duration) ShipperOption {
+	return func(config *shipperConfig) {
+		config.retry = retry
+		if timeout > 0 {
+			config.timeout = timeout
+		}
+	}
+}
+
+// WithSpillDir 发送失败的日志写入 dir, 收集服务恢复之后重新发送. limit 为目录中文件的总大小上限, 超过之后丢弃最旧的文件.
+func WithSpillDir(dir string, limit int64) ShipperOption {
+	return func(config *shipperConfig) {
+		config.spillDir = dir
+		config.spillLimit = limit
+	}
+}
+
+// HTTPShipper 批量发送日志到 HTTP 服务, 请求 body 为 JSON 数组, 每条日志的字段作为顶层 key:
+//
+//	[{"time":"...","level":"info","msg":"...","logger":"aliyundrive","file":"a.txt"}]
+type HTTPShipper struct {
+	url    string
+	config shipperConfig
+
+	mu       sync.Mutex
+	buffer   []log.LogEvent
+	overflow []log.LogEvent // 缓存满之后的日志, 由 loop 合并写入一个 spill 文件
+	flush    chan struct{}
+	spilling chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	closed   bool
+
+	dropped uint64
+}
+
+func NewHTTPShipper(u string, opts ...ShipperOption) *HTTPShipper {
+	config := shipperConfig{
+		batchSize:  100,
+		interval:   5 * time.Second,
+		bufferSize: 10000,
+		retry:      3,
+		timeout:    30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.client == nil {
+		config.client = util.NewClient()
+	}
+
+	s := &HTTPShipper{
+		url:      u,
+		config:   config,
+		flush:    make(chan struct{}, 1),
+		spilling: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if config.spillDir != "" {
+		os.MkdirAll(config.spillDir, 0755)
+	}
+	go s.loop()
+	return s
+}
+
+// Dropped 返回丢弃的日志数量
+func (s *HTTPShipper) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Send 放入缓存, 不会阻塞. 缓存满时由后台写入 spill 目录, 没有设置 spill 目录或者等待写入的日志也超过缓存大小时丢弃.
+func (s *HTTPShipper) Send(event log.LogEvent) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("shipper closed")
+	}
+	if len(s.buffer) < s.config.bufferSize {
+		s.buffer = append(s.buffer, event)
+		full := len(s.buffer) >= s.config.batchSize
+		s.mu.Unlock()
+		if full {
+			select {
+			case s.flush <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+	if s.config.spillDir == "" || len(s.overflow) >= s.config.bufferSize {
+		s.mu.Unlock()
+		atomic.AddUint64(&s.dropped, 1)
+		return errors.New("shipper buffer full")
+	}
+	s.overflow = append(s.overflow, event)
+	s.mu.Unlock()
+	select {
+	case s.spilling <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// spillOverflow 把缓存满之后的日志写入一个 spill 文件
+func (s *HTTPShipper) spillOverflow() {
+	s.mu.Lock()
+	batch := s.overflow
+	s.overflow = nil
+	s.mu.Unlock()
+	s.save(batch)
+}
+
+func (s *HTTPShipper) take() []log.LogEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.buffer)
+	if n > s.config.batchSize {
+		n = s.config.batchSize
+	}
+	batch := make([]log.LogEvent, n)
+	copy(batch, s.buffer)
+	s.buffer = append(s.buffer[:0], s.buffer[n:]...)
+	return batch
+}
+
+func (s *HTTPShipper) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buffer)
+}
+
+func (s *HTTPShipper) loop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.config.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.flush:
+		case <-s.spilling:
+			s.spillOverflow()
+			continue
+		case <-s.done:
+			s.shutdown()
+			return
+		}
+
+		s.spillOverflow()
+		ok := true
+		for ok && s.pending() > 0 {
+			ok = s.ship(context.Background(), s.take())
+		}
+		if ok {
+			s.replay()
+		}
+	}
+}
+
+// shutdown 在一个 timeout 内发送剩余的日志, 第一次失败之后其余的日志直接写入 spill 目录
+func (s *HTTPShipper) shutdown() {
+	s.spillOverflow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.timeout)
+	defer cancel()
+	ok := true
+	for s.pending() > 0 {
+		batch := s.take()
+		if ok {
+			ok = s.ship(ctx, batch)
+		} else {
+			s.save(batch)
+		}
+	}
+}
+
+// ship 发送一批日志, 失败时写入 spill 目录, 返回是否发送成功
+func (s *HTTPShipper) ship(ctx context.Context, batch []log.LogEvent) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	body, err := encode(batch)
+	if err == nil {
+		err = s.post(ctx, body)
+	}
+	if err != nil {
+		s.save(batch)
+		return false
+	}
+	return true
+}
+
+// save 写入 spill 目录, 失败时计入丢弃数量
+func (s *HTTPShipper) save(batch []log.LogEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	if s.spill(batch) != nil {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+	}
+}
+
+func (s *HTTPShipper) post(ctx context.Context, body []byte) error {
+	header := map[string]string{"content-type": "application/json"}
+	for k, v := range s.config.header {
+		header[k] = v
+	}
+
+	var err error
+	for i := uint(0); i <= s.config.retry; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(time.Duration(i) * time.Second):
+			case <-s.done:
+				// 关闭时不再等待
+				return err
+			}
+		}
+		attempt, cancel := context.WithTimeout(ctx, s.config.timeout)
+		_, err = s.config.client.POST(s.url, util.WithBody(body), util.WithHeader(header), util.WithContext(attempt))
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// record 日志的 JSON 格式, 与 log.JSONFormatter 相同, 字段冲突时添加 "fields." 前缀
+func record(event log.LogEvent) map[string]interface{} {
+	data := make(map[string]interface{}, len(event.Fields)+4)
+	for k, v := range event.Fields {
+		switch k {
+		case "time", "level", "msg", "logger", "repeat", "caller", "func", "stack":
+			k = "fields." + k
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		data[k] = v
+	}
+	data["time"] = event.Time.Format(time.RFC3339Nano)
+	data["level"] = event.Level.String()
+	data["msg"] = event.Message
+	if event.Logger != "" {
+		data["logger"] = event.Logger
+	}
+	if event.Repeat > 0 {
+		data["repeat"] = event.Repeat
+	}
+	for k, v := range map[string]string{"caller": event.Caller, "func": event.Function, "stack": event.Stack} {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+func encode(batch []log.LogEvent) ([]byte, error) {
+	records := make([]map[string]interface{}, 0, len(batch))
+	for _, event := range batch {
+		records = append(records, record(event))
+	}
+	return json.Marshal(records)
+}
+
+// spill 以 NDJSON 格式写入 spill 目录, 文件名为 spill-<纳秒时间>-<行数>.ndjson
+func (s *HTTPShipper) spill(batch []log.LogEvent) error {
+	if s.config.spillDir == "" {
+		return errors.New("spill disabled")
+	}
+
+	var buf bytes.Buffer
+	var lines int
+	for _, event := range batch {
+		line, err := json.Marshal(record(event))
+		if err != nil {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		lines++
+	}
+
+	name := filepath.Join(s.config.spillDir, fmt.Sprintf("%s%d-%d%s", spillPrefix, time.Now().UnixNano(), lines, spillSuffix))
+	if err := os.WriteFile(name+".tmp", buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	s.trimSpill()
+	return nil
+}
+
+func (s *HTTPShipper) spillFiles() []string {
+	entries, err := os.ReadDir(s.config.spillDir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, spillPrefix) && strings.HasSuffix(name, spillSuffix) {
+			files = append(files, filepath.Join(s.config.spillDir, name))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// spillLines 返回文件名中记录的行数
+func spillLines(file string) uint64 {
+	name := strings.TrimSuffix(filepath.Base(file), spillSuffix)
+	lines, _ := strconv.ParseUint(name[strings.LastIndexByte(name, '-')+1:], 10, 64)
+	return lines
+}
+
+// trimSpill 超过大小上限时删除最旧的文件
+func (s *HTTPShipper) trimSpill() {
+	if s.config.spillLimit <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.config.spillDir)
+	if err != nil {
+		return
+	}
+	var files []string
+	var total int64
+	sizes := make(map[string]int64)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, spillPrefix) || !strings.HasSuffix(name, spillSuffix) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, name)
+			sizes[name] = info.Size()
+			total += info.Size()
+		}
+	}
+	sort.Strings(files)
+	for i := 0; i < len(files) && total > s.config.spillLimit; i++ {
+		os.Remove(filepath.Join(s.config.spillDir, files[i]))
+		atomic.AddUint64(&s.dropped, spillLines(files[i]))
+		total -= sizes[files[i]]
+	}
+}
+
+// replay 重新发送 spill 目录中的日志, 从最旧的文件开始, 失败时停止
+func (s *HTTPShipper) replay() {
+	if s.config.spillDir == "" {
+		return
+	}
+	for _, file := range s.spillFiles() {
+		fd, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		var records []json.RawMessage
+		scanner := bufio.NewScanner(fd)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				records = append(records, append(json.RawMessage(nil), line...))
+			}
+		}
+		fd.Close()
+
+		if len(records) > 0 {
+			body, _ := json.Marshal(records)
+			if s.post(context.Background(), body) != nil {
+				return
+			}
+		}
+		os.Remove(file)
+
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+	}
+}
+
+// Close 发送缓存中的日志(失败时写入 spill 目录)之后返回
+func (s *HTTPShipper) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	<-s.stopped
+	return nil
+}
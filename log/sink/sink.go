@@ -0,0 +1,75 @@
+// Package sink 将日志发送到 syslog 和 HTTP 日志收集服务.
+//
+// Sink 可以作为 logrus hook(只接收 Logger 级别允许输出的日志)或者订阅者(接收所有级别的日志)使用:
+//
+//	shipper := sink.NewHTTPShipper("https://collector/api/logs", sink.WithSpillDir("/var/lib/tool/spill"))
+//	log.AddHook(sink.NewHook(shipper, log.InfoLevel))
+//	// 或者
+//	stop := sink.Attach(shipper, log.WithLevels(log.InfoLevel), log.WithPolicy(log.DropOldest))
+//	defer stop()
+package sink
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tiechui1994/tool/log"
+)
+
+// Sink 日志输出目标, Send 不能写日志(会形成循环)
+type Sink interface {
+	Send(event log.LogEvent) error
+	Close() error
+}
+
+type hook struct {
+	sink   Sink
+	levels []log.Level
+}
+
+// NewHook 返回 logrus hook, 接收 level 及更严重级别的日志, 使用 log.AddHook 添加
+func NewHook(sink Sink, level log.Level) log.Hook {
+	var levels []log.Level
+	for _, v := range logrus.AllLevels {
+		if v <= level {
+			levels = append(levels, v)
+		}
+	}
+	return &hook{sink: sink, levels: levels}
+}
+
+func (h *hook) Levels() []log.Level {
+	return h.levels
+}
+
+func (h *hook) Fire(entry *logrus.Entry) error {
+	event := log.NewEvent(entry)
+	if internal(event) {
+		return nil
+	}
+	return h.sink.Send(event)
+}
+
+// Attach 以订阅者的方式接收日志并发送到 sink, 返回的函数取消订阅并等待已经接收的事件发送完成,
+// 订阅者队列中还没有接收的事件被丢弃
+func Attach(sink Sink, opts ...log.SubscribeOption) (stop func()) {
+	sub := log.Subscribe(opts...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range sub.Events() {
+			if !internal(event) {
+				sink.Send(event)
+			}
+		}
+	}()
+	return func() {
+		log.UnSubscribe(sub)
+		<-done
+	}
+}
+
+// internal 发送日志时 util 产生的日志不再发送, 避免形成循环
+func internal(event log.LogEvent) bool {
+	return event.Logger == "util" || strings.HasPrefix(event.Logger, "util.")
+}
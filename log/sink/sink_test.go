@@ -0,0 +1,206 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tiechui1994/tool/log"
+	"github.com/tiechui1994/tool/util"
+)
+
+func TestSyslog(t *testing.T) {
+	event := log.LogEvent{
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:   log.WarnLevel,
+		Message: "upload failed",
+		Logger:  "aliyundrive",
+		Fields:  log.Fields{"file": `a"]b`, "size": 10},
+	}
+	expect := `<12>1 2024-01-02T03:04:05.000000Z host tool 1 aliyundrive [fields@32473 file="a\"\]b" size="10"] upload failed`
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer udp.Close()
+	s, err := NewSyslog("udp", udp.LocalAddr().String(), WithHostname("host"), WithAppName("tool"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	s.pid = 1
+	if err = s.Send(event); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	buf := make([]byte, 1024)
+	udp.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != expect {
+		t.Fatalf("udp: %v %s", err, buf[:n])
+	}
+	s.Close()
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer tcp.Close()
+	s, err = NewSyslog("tcp", tcp.Addr().String(), WithHostname("host"), WithAppName("tool"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer s.Close()
+	s.pid = 1
+	conn, _ := tcp.Accept()
+	defer conn.Close()
+	s.Send(event)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	length, _ := reader.ReadString(' ')
+	line := make([]byte, len(expect))
+	io.ReadFull(reader, line)
+	if length != fmt.Sprintf("%d ", len(expect)) || string(line) != expect {
+		t.Fatalf("tcp: %q %s", length, line)
+	}
+}
+
+func TestHTTPShipper(t *testing.T) {
+	var down int32 = 1
+	var mu sync.Mutex
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var records []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil || r.Header.Get("X-Token") != "t" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, records...)
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	shipper := NewHTTPShipper(server.URL,
+		WithShipperClient(util.NewClient(util.WithClientRetry(0))),
+		WithShipperHeader(map[string]string{"X-Token": "t"}),
+		WithBatch(2, 20*time.Millisecond),
+		WithShipperRetry(0, time.Second),
+		WithSpillDir(dir, 0),
+	)
+	for i := 0; i < 5; i++ {
+		shipper.Send(log.LogEvent{Time: time.Now(), Level: log.InfoLevel, Message: "spill", Fields: log.Fields{"i": i, "msg": "x"}})
+	}
+	time.Sleep(200 * time.Millisecond)
+	if files := shipper.spillFiles(); len(files) == 0 {
+		t.Fatalf("no spill files")
+	}
+
+	// 恢复之后重新发送 spill 目录中的日志
+	atomic.StoreInt32(&down, 0)
+	for i := 0; i < 100 && len(shipper.spillFiles()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop := Attach(shipper, log.WithLevels(log.WarnLevel))
+	log.Named("speech").Warnw("shipped", "id", "r1")
+	log.Named("util").Warnln("internal")
+	for i := 0; i < 100 && shipper.pending() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	shipper.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var spilled, shipped int
+	for _, record := range received {
+		switch record["msg"] {
+		case "spill":
+			spilled++
+			if record["fields.msg"] != "x" {
+				t.Fatalf("field conflict: %v", record)
+			}
+		case "shipped":
+			shipped++
+			if record["logger"] != "speech" || record["id"] != "r1" || record["level"] != "warning" {
+				t.Fatalf("record: %v", record)
+			}
+		default:
+			t.Fatalf("unexpected record: %v", record)
+		}
+	}
+	if spilled != 5 || shipped != 1 || len(shipper.spillFiles()) != 0 {
+		t.Fatalf("spilled=%v shipped=%v files=%v", spilled, shipped, shipper.spillFiles())
+	}
+	if err := shipper.Send(log.LogEvent{}); err == nil {
+		t.Fatalf("send after close")
+	}
+}
+
+func TestSpillLimit(t *testing.T) {
+	dir := t.TempDir()
+	shipper := NewHTTPShipper("http://127.0.0.1:1", WithSpillDir(dir, 1), WithBufferSize(1), WithBatch(100, time.Hour))
+	defer shipper.Close()
+	for i := 0; i < 3; i++ {
+		shipper.Send(log.LogEvent{Message: strings.Repeat("x", 10)})
+	}
+	// 缓存满之后的日志由后台合并写入一个文件
+	for i := 0; i < 100 && shipper.Dropped() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 || shipper.Dropped() != 2 {
+		t.Fatalf("spill limit: %v dropped=%v", len(entries), shipper.Dropped())
+	}
+}
+
+func TestShipperClose(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	// 关闭时只等待一个 timeout, 之后的批次直接写入 spill 目录
+	dir := t.TempDir()
+	shipper := NewHTTPShipper(server.URL,
+		WithShipperClient(util.NewClient(util.WithClientRetry(0))),
+		WithBatch(1, time.Hour),
+		WithShipperRetry(0, 100*time.Millisecond),
+		WithSpillDir(dir, 0),
+	)
+	shipper.mu.Lock()
+	for i := 0; i < 10; i++ {
+		shipper.buffer = append(shipper.buffer, log.LogEvent{Message: "close"})
+	}
+	shipper.mu.Unlock()
+
+	start := time.Now()
+	shipper.Close()
+	if time.Since(start) > time.Second {
+		t.Fatalf("close blocked: %v", time.Since(start))
+	}
+	var lines uint64
+	for _, file := range shipper.spillFiles() {
+		lines += spillLines(file)
+	}
+	if lines != 10 || shipper.Dropped() != 0 {
+		t.Fatalf("spilled=%v dropped=%v", lines, shipper.Dropped())
+	}
+}
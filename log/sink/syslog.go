@@ -0,0 +1,247 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tiechui1994/tool/log"
+)
+
+// syslog facility, RFC 5424 6.2.1
+const (
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityLocal0 = 16
+)
+
+// sdID 结构化数据的 SD-ID, 使用保留的 enterprise number 32473(RFC 5612)
+const sdID = "fields@32473"
+
+type syslogConfig struct {
+	facility int
+	appName  string
+	hostname string
+	timeout  time.Duration
+}
+
+type SyslogOption func(*syslogConfig)
+
+// WithFacility 默认 FacilityUser
+func WithFacility(facility int) SyslogOption {
+	return func(config *syslogConfig) {
+		config.facility = facility
+	}
+}
+
+// WithAppName 默认为程序名称
+func WithAppName(name string) SyslogOption {
+	return func(config *syslogConfig) {
+		config.appName = name
+	}
+}
+
+func WithHostname(hostname string) SyslogOption {
+	return func(config *syslogConfig) {
+		config.hostname = hostname
+	}
+}
+
+// Syslog 使用 RFC 5424 格式发送日志. TCP 使用 octet counting(RFC 6587) 分帧, unix stream 使用换行分帧.
+type Syslog struct {
+	network string
+	addr    string
+	config  syslogConfig
+	pid     int
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog 连接 syslog 服务, network 为 "udp", "tcp", "unix", "unixgram".
+// network 和 addr 为空时连接本机的 syslog(/dev/log, /var/run/syslog, /var/run/log).
+func NewSyslog(network, addr string, opts ...SyslogOption) (*Syslog, error) {
+	config := syslogConfig{
+		facility: FacilityUser,
+		appName:  filepath.Base(os.Args[0]),
+		timeout:  5 * time.Second,
+	}
+	config.hostname, _ = os.Hostname()
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	s := &Syslog{network: network, addr: addr, config: config, pid: os.Getpid()}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Syslog) connect() error {
+	if s.network != "" || s.addr != "" {
+		conn, err := net.DialTimeout(s.network, s.addr, s.config.timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}
+
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.DialTimeout(network, path, s.config.timeout)
+			if err == nil {
+				s.network, s.addr, s.conn = network, path, conn
+				return nil
+			}
+		}
+	}
+	return errors.New("local syslog server not found")
+}
+
+func severity(level log.Level) int {
+	switch level {
+	case log.PanicLevel:
+		return 0 // emergency
+	case log.FatalLevel:
+		return 2 // critical
+	case log.ErrorLevel:
+		return 3
+	case log.WarnLevel:
+		return 4
+	case log.InfoLevel:
+		return 6
+	default:
+		return 7 // debug
+	}
+}
+
+// header 字段: PRINTUSASCII(33-126), 为空时使用 "-"
+func header(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	if len(value) > max {
+		value = value[:max]
+	}
+	return value
+}
+
+// paramName SD-NAME: PRINTUSASCII, 不包括 '=', ' ', ']', '"', 最长 32
+func paramName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= 32 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+var paramEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// format RFC 5424: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG, Logger 名称作为 MSGID
+func (s *Syslog) format(event log.LogEvent) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d %s ",
+		s.config.facility*8+severity(event.Level),
+		event.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(s.config.hostname, 255),
+		header(s.config.appName, 48),
+		s.pid,
+		header(event.Logger, 32),
+	)
+
+	params := event.Fields
+	if event.Caller != "" {
+		// 调用栈是多行的, 不发送
+		params = make(log.Fields, len(event.Fields)+1)
+		for k, v := range event.Fields {
+			params[k] = v
+		}
+		params["caller"] = event.Caller
+	}
+	if len(params) == 0 {
+		buf.WriteString("-")
+	} else {
+		keys := make([]string, 0, len(params))
+		for k := range params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteString("[" + sdID)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, ` %s="%s"`, paramName(k), paramEscaper.Replace(fmt.Sprint(params[k])))
+		}
+		buf.WriteString("]")
+	}
+
+	if event.Repeat > 0 {
+		fmt.Fprintf(&buf, " %s (repeated %d times)", event.Message, event.Repeat+1)
+	} else {
+		buf.WriteString(" " + event.Message)
+	}
+	return buf.Bytes()
+}
+
+func (s *Syslog) frame(message []byte) []byte {
+	switch s.network {
+	case "tcp", "tcp4", "tcp6":
+		return append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	case "unix":
+		return append(message, '\n')
+	default:
+		return message
+	}
+}
+
+// Send 发送日志, 写入失败时重新连接一次
+func (s *Syslog) Send(event log.LogEvent) error {
+	data := s.frame(s.format(event))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for i := 0; i < 2; i++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				continue
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(s.config.timeout))
+		if _, err = s.conn.Write(data); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
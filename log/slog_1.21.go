@@ -0,0 +1,139 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// SlogHandler 实现 slog.Handler, 将 slog 的日志输出到 Logger, 与 Logger 使用相同的级别, 订阅者和 Formatter.
+// group 使用 "." 连接作为字段名的前缀, 例如 slog.Group("req", "method", "GET") 的字段名为 "req.method".
+type SlogHandler struct {
+	logger *Logger
+	fields Fields
+	prefix string
+}
+
+// NewSlogHandler logger 为 nil 时使用全局 Logger
+func NewSlogHandler(logger *Logger) *SlogHandler {
+	if logger == nil {
+		logger = std
+	}
+	return &SlogHandler{logger: logger}
+}
+
+// Slog 返回输出到 Logger 的 *slog.Logger, 用于使用 slog 的第三方库
+func (l *Logger) Slog() *slog.Logger {
+	return slog.New(NewSlogHandler(l))
+}
+
+// Slog 返回输出到全局 Logger 的 *slog.Logger
+func Slog() *slog.Logger {
+	return std.Slog()
+}
+
+// fromSlogLevel slog.LevelError 以上对应 ErrorLevel, 不会对应 FatalLevel(会退出程序)
+func fromSlogLevel(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError:
+		return ErrorLevel
+	case level >= slog.LevelWarn:
+		return WarnLevel
+	case level >= slog.LevelInfo:
+		return InfoLevel
+	case level >= slog.LevelDebug:
+		return DebugLevel
+	default:
+		return TraceLevel
+	}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(fromSlogLevel(level))
+}
+
+// Handle 字段的优先级从低到高为 Logger 的字段, ctx 中 Logger(见 WithContext) 的字段, WithAttrs 和 record 的 attr
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	var ctxFields Fields
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*Logger); ok && logger != nil {
+			ctxFields = logger.fields
+		}
+	}
+
+	fields := make(Fields, len(h.fields)+len(h.logger.fields)+len(ctxFields)+record.NumAttrs())
+	for k, v := range h.logger.fields {
+		fields[k] = v
+	}
+	for k, v := range ctxFields {
+		fields[k] = v
+	}
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(fields, h.prefix, attr)
+		return true
+	})
+	if len(fields) == 0 {
+		fields = nil
+	}
+
+	level := fromSlogLevel(record.Level)
+	var frames []runtime.Frame
+	if captureEnabled(level) {
+		frames = trimCallers(callers(0), record.PC)
+	}
+	h.logger.write(frames, level, record.Message, record.Message, fields)
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	fields := make(Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, attr := range attrs {
+		addAttr(fields, h.prefix, attr)
+	}
+	return &SlogHandler{logger: h.logger, fields: fields, prefix: h.prefix}
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{logger: h.logger, fields: h.fields, prefix: h.prefix + name + "."}
+}
+
+// addAttr 展开 group, 忽略空的 attr
+func addAttr(fields Fields, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if attr.Key == "" && value.Kind() != slog.KindGroup {
+		return
+	}
+
+	switch value.Kind() {
+	case slog.KindGroup:
+		// key 为空的 group 直接展开
+		if attr.Key != "" {
+			prefix = prefix + attr.Key + "."
+		}
+		for _, v := range value.Group() {
+			addAttr(fields, prefix, v)
+		}
+	case slog.KindTime:
+		fields[prefix+attr.Key] = value.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		fields[prefix+attr.Key] = value.Duration().String()
+	default:
+		fields[prefix+attr.Key] = value.Any()
+	}
+}
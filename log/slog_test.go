@@ -0,0 +1,83 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlog(t *testing.T) {
+	defer ResetModuleLevel("slogtest")
+	sub := NewSubscriber("slog")
+	hook.AddSubscriber(sub)
+	defer UnSubscribe(sub)
+
+	logger := Named("slogtest").With("job", "j1").Slog()
+	SetModuleLevel("slogtest", WarnLevel)
+	if logger.Enabled(context.Background(), slog.LevelInfo) || !logger.Enabled(context.Background(), slog.LevelWarn) {
+		t.Fatalf("slog level not mapped")
+	}
+
+	logger.With("file", "a.txt").WithGroup("req").With("method", "GET").Warn("upload failed",
+		"attempt", 2, slog.Group("resp", "code", 503), slog.Group("", "inline", true), slog.Attr{},
+		"took", time.Second)
+	select {
+	case event := <-sub.Events():
+		expect := map[string]interface{}{
+			"job": "j1", "file": "a.txt", "req.method": "GET", "req.attempt": int64(2),
+			"req.resp.code": int64(503), "req.inline": true, "req.took": "1s",
+		}
+		if event.Logger != "slogtest" || event.Level != WarnLevel || event.Message != "upload failed" ||
+			len(event.Fields) != len(expect) {
+			t.Fatalf("event: %+v", event)
+		}
+		for k, v := range expect {
+			if event.Fields[k] != v {
+				t.Fatalf("field %v: %#v != %#v", k, event.Fields[k], v)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event")
+	}
+
+	// ctx 中 Logger 的字段
+	ctx := WithContext(context.Background(), Named("other").With("request_id", "r1", "job", "j2"))
+	logger.WarnContext(ctx, "ctx", "job", "j3")
+	select {
+	case event := <-sub.Events():
+		if event.Logger != "slogtest" || event.Fields["request_id"] != "r1" || event.Fields["job"] != "j3" {
+			t.Fatalf("ctx event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no ctx event")
+	}
+
+	// 调用位置为 slog 的调用者
+	SetReportCaller(true)
+	SetReportStack(true)
+	defer SetReportCaller(false)
+	defer SetReportStack(false)
+	_, _, line, _ := runtime.Caller(0)
+	logger.Error("failed")
+	event := <-sub.Events()
+	if event.Caller != fmt.Sprintf("log/slog_test.go:%d", line+1) ||
+		!strings.HasPrefix(event.Stack, "github.com/tiechui1994/tool/log.TestSlog\n") {
+		t.Fatalf("slog caller: %v %v", event.Caller, event.Stack)
+	}
+
+	for level, expect := range map[slog.Level]Level{
+		slog.LevelDebug - 4: TraceLevel, slog.LevelDebug: DebugLevel, slog.LevelInfo + 1: InfoLevel,
+		slog.LevelWarn: WarnLevel, slog.LevelError + 4: ErrorLevel,
+	} {
+		if fromSlogLevel(level) != expect {
+			t.Fatalf("level %v: %v", level, fromSlogLevel(level))
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHistorySize = 1000
+	streamHeartbeat    = 15 * time.Second
+)
+
+// eventRing 保存最近的事件
+type eventRing struct {
+	events []LogEvent
+	next   int
+	full   bool
+}
+
+func newEventRing(size int) *eventRing {
+	if size < 0 {
+		size = 0
+	}
+	return &eventRing{events: make([]LogEvent, size)}
+}
+
+func (r *eventRing) add(event LogEvent) {
+	if len(r.events) == 0 {
+		return
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// last 返回最近的 n 个事件, 从旧到新
+func (r *eventRing) last(n int) []LogEvent {
+	size := r.next
+	if r.full {
+		size = len(r.events)
+	}
+	if n > size {
+		n = size
+	}
+	if n <= 0 {
+		return nil
+	}
+	val := make([]LogEvent, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		val = append(val, r.events[(i+len(r.events))%len(r.events)])
+	}
+	return val
+}
+
+// SetHistorySize 设置 StreamHandler 回放使用的最近事件数量, 默认 1000
+func SetHistorySize(size int) {
+	hook.SetHistorySize(size)
+}
+
+// eventRecord 事件的 JSON 格式, 与 JSONFormatter 相同, 字段作为顶层 key, 冲突时添加 "fields." 前缀
+func eventRecord(event LogEvent) map[string]interface{} {
+	data := make(map[string]interface{}, len(event.Fields)+4)
+	for k, v := range event.Fields {
+		switch k {
+		case "time", "level", "msg", loggerKey, "repeat", callerKey, functionKey, stackKey:
+			k = "fields." + k
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		data[k] = v
+	}
+	data["time"] = event.Time.Format(time.RFC3339Nano)
+	data["level"] = event.Level.String()
+	data["msg"] = event.Message
+	if event.Logger != "" {
+		data[loggerKey] = event.Logger
+	}
+	if event.Repeat > 0 {
+		data["repeat"] = event.Repeat
+	}
+	for k, v := range map[string]string{callerKey: event.Caller, functionKey: event.Function, stackKey: event.Stack} {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+type streamFilter struct {
+	level  Level
+	logger string
+	fields map[string]string
+}
+
+func (f *streamFilter) match(event LogEvent) bool {
+	if event.Level > f.level {
+		return false
+	}
+	if f.logger != "" && event.Logger != f.logger && !strings.HasPrefix(event.Logger, f.logger+".") {
+		return false
+	}
+	for k, v := range f.fields {
+		value, ok := event.Fields[k]
+		if !ok || fmt.Sprint(value) != v {
+			return false
+		}
+	}
+	return true
+}
+
+var streamID uint64
+
+// StreamHandler 以 SSE(默认) 或者 NDJSON(format=ndjson 或者 Accept: application/x-ndjson) 格式输出日志, 参数:
+//
+//	level=warning       只输出 warning 及更严重的日志, 默认 trace
+//	logger=aliyundrive  只输出 aliyundrive 及其子模块的日志
+//	field.file=a.txt    字段 file 的值为 a.txt, 可以有多个
+//	backfill=100        先输出最近的 100 条日志
+//
+// 客户端断开连接时取消订阅. 客户端读取太慢时丢弃旧的日志.
+func StreamHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := streamFilter{level: TraceLevel, logger: query.Get("logger"), fields: make(map[string]string)}
+		if value := query.Get("level"); value != "" {
+			level, err := logrus.ParseLevel(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter.level = level
+		}
+		for k, v := range query {
+			if strings.HasPrefix(k, "field.") && len(v) > 0 {
+				filter.fields[strings.TrimPrefix(k, "field.")] = v[0]
+			}
+		}
+		backfill := 0
+		if value := query.Get("backfill"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid backfill", http.StatusBadRequest)
+				return
+			}
+			backfill = n
+		}
+		ndjson := query.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+
+		id := fmt.Sprintf("stream-%d", atomic.AddUint64(&streamID, 1))
+		sub := NewSubscriber(id, WithLevels(filter.level), WithPolicy(DropOldest))
+		var history []LogEvent
+		if backfill > 0 {
+			// 过滤之前不知道需要多少条, 取出全部历史
+			history = hook.AddSubscriberWithHistory(sub, math.MaxInt32)
+		} else {
+			hook.AddSubscriber(sub)
+		}
+		defer UnSubscribe(sub)
+
+		if ndjson {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+		}
+		w.WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(w)
+		write := func(event LogEvent) error {
+			if !ndjson {
+				if _, err := fmt.Fprint(w, "event: log\ndata: "); err != nil {
+					return err
+				}
+			}
+			// Encode 以换行结尾
+			if err := encoder.Encode(eventRecord(event)); err != nil {
+				return err
+			}
+			if !ndjson {
+				if _, err := fmt.Fprint(w, "\n"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		// 从历史中选出满足条件的最近 backfill 条
+		var matched []LogEvent
+		for i := len(history) - 1; i >= 0 && len(matched) < backfill; i-- {
+			if filter.match(history[i]) {
+				matched = append(matched, history[i])
+			}
+		}
+		for i := len(matched) - 1; i >= 0; i-- {
+			if write(matched[i]) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if !ndjson {
+					if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+						return
+					}
+					flusher.Flush()
+				}
+			case event, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				if !filter.match(event) {
+					continue
+				}
+				if write(event) != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
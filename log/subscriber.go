@@ -2,31 +2,124 @@ package log
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	defaultQueueSize    = 512
+	defaultBlockTimeout = time.Second
+)
+
 type LogEvent struct {
+	Time    time.Time
 	Level   logrus.Level
 	Message string
+	Logger  string // Logger 名称, 全局 Logger 为空
+	Fields  Fields // 可能为 nil, 订阅者之间共享, 不要修改
+	Repeat  int    // Coalesce 策略下合并的相同事件数量
+
+	Caller   string // 调用位置 "dir/file.go:line", SetReportCaller 开启
+	Function string // 调用函数, SetReportCaller 开启
+	Stack    string // 调用栈, SetReportStack 开启时 Error 和 Fatal 级别记录
+}
+
+// DeliveryPolicy 订阅者队列满时的处理方式
+type DeliveryPolicy int
+
+const (
+	// DropNewest 丢弃新的事件
+	DropNewest DeliveryPolicy = iota
+	// DropOldest 丢弃队列中最旧的事件
+	DropOldest
+	// Block 阻塞写日志的 goroutine, 超时之后丢弃新的事件
+	Block
+	// Coalesce 与队列中最后一个事件相同(Logger, Level, Message)时合并(Repeat 加 1), 否则丢弃新的事件
+	Coalesce
+)
+
+type subscriberConfig struct {
+	levels    []logrus.Level
+	policy    DeliveryPolicy
+	queueSize int
+	timeout   time.Duration
+}
+
+type SubscribeOption func(*subscriberConfig)
+
+// WithLevels 只接收指定级别(及更严重级别)的事件
+func WithLevels(levels ...logrus.Level) SubscribeOption {
+	return func(config *subscriberConfig) {
+		config.levels = levels
+	}
+}
+
+func WithPolicy(policy DeliveryPolicy) SubscribeOption {
+	return func(config *subscriberConfig) {
+		config.policy = policy
+	}
+}
+
+// WithQueueSize 队列长度, 默认 512
+func WithQueueSize(size int) SubscribeOption {
+	return func(config *subscriberConfig) {
+		if size > 0 {
+			config.queueSize = size
+		}
+	}
+}
+
+// WithBlockTimeout Block 策略下的最长等待时间, 默认 1s
+func WithBlockTimeout(timeout time.Duration) SubscribeOption {
+	return func(config *subscriberConfig) {
+		config.timeout = timeout
+	}
 }
 
-// BaseSubscriber 是一个通用的订阅者实现
+// BaseSubscriber 是一个通用的订阅者实现. 每个订阅者有一个有序队列, 由一个 goroutine 按照顺序发送到 Events 通道.
 type BaseSubscriber struct {
 	id     string
-	levels []logrus.Level
+	config subscriberConfig
 	events chan LogEvent
+
 	mu     sync.Mutex
+	queue  []LogEvent
+	ready  chan struct{} // 队列中有新的事件
+	space  chan struct{} // 队列中有空位
+	done   chan struct{}
 	closed bool
+
+	dropped uint64
 }
 
 // NewBaseSubscriber 创建一个新的基本订阅者
 func NewBaseSubscriber(id string, levels ...logrus.Level) *BaseSubscriber {
-	return &BaseSubscriber{
+	return NewSubscriber(id, WithLevels(levels...))
+}
+
+// NewSubscriber 创建订阅者, 默认接收所有级别, 队列满时丢弃新的事件
+func NewSubscriber(id string, opts ...SubscribeOption) *BaseSubscriber {
+	config := subscriberConfig{
+		policy:    DropNewest,
+		queueSize: defaultQueueSize,
+		timeout:   defaultBlockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	s := &BaseSubscriber{
 		id:     id,
-		levels: levels,
-		events: make(chan LogEvent, 512), // 使用缓冲通道
+		config: config,
+		events: make(chan LogEvent),
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
 	}
+	go s.dispatch()
+	return s
 }
 
 // uuid 返回订阅者的唯一ID
@@ -36,10 +129,10 @@ func (s *BaseSubscriber) uuid() string {
 
 // filter 根据预设的日志级别过滤事件
 func (s *BaseSubscriber) filter(event LogEvent) bool {
-	if len(s.levels) == 0 { // 如果没有指定级别，则接收所有事件
+	if len(s.config.levels) == 0 { // 如果没有指定级别，则接收所有事件
 		return true
 	}
-	for _, level := range s.levels {
+	for _, level := range s.config.levels {
 		if event.Level <= level { // 接收等于或低于指定级别的事件
 			return true
 		}
@@ -47,32 +140,135 @@ func (s *BaseSubscriber) filter(event LogEvent) bool {
 	return false
 }
 
-// Events 返回接收事件的通道
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// deliver 按照策略将事件放入队列, 不会写日志(避免递归)
+func (s *BaseSubscriber) deliver(event LogEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	if s.config.policy == Coalesce && len(s.queue) > 0 {
+		last := &s.queue[len(s.queue)-1]
+		if last.Logger == event.Logger && last.Level == event.Level && last.Message == event.Message {
+			last.Repeat++
+			return
+		}
+	}
+
+	if len(s.queue) >= s.config.queueSize {
+		switch s.config.policy {
+		case DropOldest:
+			s.queue[0] = LogEvent{}
+			s.queue = s.queue[1:]
+			atomic.AddUint64(&s.dropped, 1)
+		case Block:
+			if !s.waitSpace() {
+				atomic.AddUint64(&s.dropped, 1)
+				return
+			}
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			return
+		}
+	}
+
+	s.queue = append(s.queue, event)
+	notify(s.ready)
+}
+
+// waitSpace 等待队列中有空位, 调用时持有 mu, 等待期间释放
+func (s *BaseSubscriber) waitSpace() bool {
+	timer := time.NewTimer(s.config.timeout)
+	defer timer.Stop()
+	for len(s.queue) >= s.config.queueSize {
+		s.mu.Unlock()
+		select {
+		case <-s.space:
+		case <-timer.C:
+			s.mu.Lock()
+			return len(s.queue) < s.config.queueSize
+		case <-s.done:
+			s.mu.Lock()
+			return false
+		}
+		s.mu.Lock()
+		if s.closed {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *BaseSubscriber) dispatch() {
+	defer close(s.events)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.ready:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		event := s.queue[0]
+		s.queue[0] = LogEvent{}
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		notify(s.space)
+
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Events 返回接收事件的通道, Close 之后通道被关闭
 func (s *BaseSubscriber) Events() chan LogEvent {
 	return s.events
 }
 
-// Close 关闭订阅者，释放资源
+// Dropped 返回由于队列满被丢弃的事件数量
+func (s *BaseSubscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close 关闭订阅者，释放资源. 队列中未发送的事件被丢弃.
 func (s *BaseSubscriber) Close() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if !s.closed {
-		close(s.events)
 		s.closed = true
+		s.queue = nil
+		close(s.done)
 	}
 }
 
 // Subscriber 定义了订阅者的接口
 type Subscriber interface {
-	uuid() string // 返回订阅者的唯一ID
+	uuid() string               // 返回订阅者的唯一ID
 	filter(event LogEvent) bool // 决定是否接收此事件
-	Events() chan LogEvent // 返回接收事件的通道
-	Close() // 关闭订阅者，释放资源
+	deliver(event LogEvent)     // 按照顺序放入队列
+	Events() chan LogEvent      // 返回接收事件的通道
+	Dropped() uint64            // 返回丢弃的事件数量
+	Close()                     // 关闭订阅者，释放资源
 }
 
 // SubscriberHook 是一个 Logrus Hook，负责将日志事件分发给订阅者
 type SubscriberHook struct {
 	subscribers map[string]Subscriber
+	history     *eventRing
 	mu          sync.RWMutex
 }
 
@@ -80,6 +276,7 @@ type SubscriberHook struct {
 func NewSubscriberHook() *SubscriberHook {
 	return &SubscriberHook{
 		subscribers: make(map[string]Subscriber),
+		history:     newEventRing(defaultHistorySize),
 	}
 }
 
@@ -90,6 +287,21 @@ func (h *SubscriberHook) AddSubscriber(sub Subscriber) {
 	h.subscribers[sub.uuid()] = sub
 }
 
+// AddSubscriberWithHistory 添加订阅者, 返回最近的 n 个事件(从旧到新)
+func (h *SubscriberHook) AddSubscriberWithHistory(sub Subscriber, n int) []LogEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[sub.uuid()] = sub
+	return h.history.last(n)
+}
+
+// SetHistorySize 设置保存的最近事件数量, 默认 1000, 0 表示不保存
+func (h *SubscriberHook) SetHistorySize(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = newEventRing(size)
+}
+
 // RemoveSubscriber 移除一个订阅者
 func (h *SubscriberHook) RemoveSubscriber(id string) {
 	h.mu.Lock()
@@ -105,29 +317,57 @@ func (h *SubscriberHook) Levels() []logrus.Level {
 	return logrus.AllLevels // 处理所有日志级别
 }
 
-// Fire 是 Hook 的核心方法，当有日志事件时被调用
-func (h *SubscriberHook) Fire(entry *logrus.Entry) error {
+// NewEvent 将 logrus.Entry 转换为 LogEvent, Logger 名称从字段中取出
+func NewEvent(entry *logrus.Entry) LogEvent {
 	event := LogEvent{
+		Time:    entry.Time,
 		Level:   entry.Level,
 		Message: entry.Message,
 	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if len(entry.Data) > 0 {
+		event.Fields = make(Fields, len(entry.Data))
+		for k, v := range entry.Data {
+			event.Fields[k] = v
+		}
+		for key, value := range map[string]*string{
+			loggerKey:   &event.Logger,
+			callerKey:   &event.Caller,
+			functionKey: &event.Function,
+			stackKey:    &event.Stack,
+		} {
+			if v, ok := event.Fields[key].(string); ok {
+				*value = v
+				delete(event.Fields, key)
+			}
+		}
+		if len(event.Fields) == 0 {
+			event.Fields = nil
+		}
+	}
+	return event
+}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// Fire 是 Hook 的核心方法，当有日志事件时被调用. 事件按照调用顺序放入每个订阅者的队列.
+func (h *SubscriberHook) Fire(entry *logrus.Entry) error {
+	event := NewEvent(entry)
 
+	// 记录历史和复制订阅者在同一个锁中, 新的订阅者不会重复或者遗漏事件.
+	// Block 策略可能会等待, 发送时不能持有锁.
+	h.mu.Lock()
+	h.history.add(event)
+	subscribers := make([]Subscriber, 0, len(h.subscribers))
 	for _, sub := range h.subscribers {
-		// 使用 goroutine 非阻塞地发送事件，避免阻塞日志写入
-		go func(s Subscriber) {
-			if s.filter(event) {
-				select {
-				case s.Events() <- event:
-					// 事件发送成功
-				default:
-					// 通道已满，丢弃事件，或者可以实现更复杂的重试/错误处理机制
-					logrus.Warnf("Subscriber %s channel is full, dropping event: %s", s.uuid(), event.Message)
-				}
-			}
-		}(sub)
+		subscribers = append(subscribers, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subscribers {
+		if sub.filter(event) {
+			sub.deliver(event)
+		}
 	}
 	return nil
-}
\ No newline at end of file
+}
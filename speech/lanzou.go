@@ -3,7 +3,6 @@ package speech
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -11,6 +10,7 @@ import (
 	"time"
 
 	"github.com/tidwall/gjson"
+	"github.com/tiechui1994/tool/log"
 	"github.com/tiechui1994/tool/util"
 )
 
@@ -22,6 +22,8 @@ type FileInfo struct {
 	Download string `json:"download"`
 }
 
+var logger = log.Named("speech")
+
 var (
 	hosts = []string{
 		"lanzouy.com",
@@ -31,8 +33,22 @@ var (
 		"lanzouo.com",
 		"lanzoul.com",
 	}
+
+	mirror    = util.NewMirror(hosts...)
+	devMirror = util.NewMirror(append([]string{"lanzoug.com"}, hosts...)...)
 )
 
+// selectMirror 返回更新 shareURL 和 endpoint 的回调, 后续请求使用同一个镜像
+func selectMirror(shareURL, endpoint *string) func(u string) {
+	return func(u string) {
+		*shareURL = u
+		uRL, err := url.Parse(u)
+		if err == nil {
+			*endpoint = uRL.Scheme + "://" + uRL.Host
+		}
+	}
+}
+
 func FetchLanZouInfo(shareURL, pwd string) ([]FileInfo, error) {
 	rURL := regexp.MustCompile(`^(https?://[a-zA-Z0-9-]*?\.?lanzou[a-z].com)/`)
 	urls := rURL.FindAllStringSubmatch(shareURL, 1)
@@ -41,26 +57,8 @@ func FetchLanZouInfo(shareURL, pwd string) ([]FileInfo, error) {
 	}
 	endpoint := urls[0][1]
 
-	// random host
-	host := func(cur string) (val string) {
-		defer func() {
-			uRL, _ := url.Parse(shareURL)
-			uRL.Host = val
-			shareURL = uRL.String()
-			endpoint = "https://" + val
-		}()
-
-		name := cur[:strings.Index(cur, ".")]
-		for i, v := range hosts {
-			if strings.HasSuffix(cur, v) && i < len(hosts)-1 {
-				return name + "." + hosts[i+1]
-			}
-		}
-
-		return name + "." + hosts[0]
-	}
-
-	raw, err := util.GET(shareURL, util.WithRetry(3), util.WithRandomHost(host))
+	raw, err := util.GET(shareURL, util.WithRetry(3), util.WithCharset(nil, "gb18030"),
+		util.WithMirror(mirror, selectMirror(&shareURL, &endpoint)))
 	if err != nil {
 		return nil, fmt.Errorf("get share failed: %w", err)
 	}
@@ -99,7 +97,7 @@ func fileDirectURL(raw []byte, shareURL, pwd, endpoint string) ([]FileInfo, erro
 	form, _ := strconv.Unquote(values[0][1])
 	u := endpoint + "/ajaxm.php"
 	body := form + pwd
-	log.Printf("request ajaxm url:%v body: %v", u, body)
+	logger.Debugln("request ajaxm url:%v body: %v", u, body)
 	raw, err := util.POST(u, util.WithBody(body), util.WithRetry(3),
 		util.WithHeader(map[string]string{
 			"Content-Type":   "application/x-www-form-urlencoded",
@@ -210,7 +208,7 @@ func fileInDirectURL(raw []byte, shareURL, pwd, endpoint string) ([]FileInfo, er
 
 	u := endpoint + "/filemoreajax.php"
 	body := form.Encode()
-	log.Printf("request filemoreajax url: %v, body: %v", u, body)
+	logger.Debugln("request filemoreajax url: %v, body: %v", u, body)
 	raw, err = util.POST(u,
 		util.WithBody(body),
 		util.WithRetry(3),
@@ -244,7 +242,7 @@ func fileInDirectURL(raw []byte, shareURL, pwd, endpoint string) ([]FileInfo, er
 		response.Text[i].Share = endpoint + "/" + v.ID
 		response.Text[i].Download, err = fetchFileURL(response.Text[i].Share)
 		if err != nil {
-			log.Printf("fetch url %v failed: %v",
+			logger.Warnln("fetch url %v failed: %v",
 				response.Text[i].Share, err)
 		}
 		time.Sleep(time.Millisecond * 2000)
@@ -261,26 +259,8 @@ func fetchFileURL(shareURL string) (string, error) {
 	}
 	endpoint := urls[0][1]
 
-	// random host
-	host := func(cur string) (val string) {
-		defer func() {
-			uRL, _ := url.Parse(shareURL)
-			uRL.Host = val
-			shareURL = uRL.String()
-			endpoint = "https://" + val
-		}()
-
-		name := cur[:strings.Index(cur, ".")]
-		for i, v := range hosts {
-			if strings.HasSuffix(cur, v) && i < len(hosts)-1 {
-				return name + "." + hosts[i+1]
-			}
-		}
-
-		return name + "." + hosts[0]
-	}
-
-	raw, err := util.GET(shareURL, util.WithRetry(3), util.WithRandomHost(host))
+	raw, err := util.GET(shareURL, util.WithRetry(3), util.WithCharset(nil, "gb18030"),
+		util.WithMirror(mirror, selectMirror(&shareURL, &endpoint)))
 	if err != nil {
 		return "", fmt.Errorf("get code file url failed: %w", err)
 	}
@@ -300,8 +280,9 @@ func fetchFileURL(shareURL string) (string, error) {
 	time.Sleep(time.Second)
 
 	fn := endpoint + values[0][1]
-	log.Printf("fn url: %v", fn)
-	raw, err = util.GET(fn, util.WithRetry(2), util.WithHeader(map[string]string{"Referer": shareURL}))
+	logger.Debugln("fn url: %v", fn)
+	raw, err = util.GET(fn, util.WithRetry(2), util.WithCharset(nil, "gb18030"),
+		util.WithHeader(map[string]string{"Referer": shareURL}))
 	if err != nil {
 		return "", fmt.Errorf("get iframe failed: %w", err)
 	}
@@ -324,7 +305,7 @@ func fetchFileURL(shareURL string) (string, error) {
 
 	// key, value
 	values[0][1] = strings.ReplaceAll(values[0][1], `'`, `"`)
-	log.Printf("ajax regex data: %v", values[0][1])
+	logger.Debugln("ajax regex data: %v", values[0][1])
 	r = regexp.MustCompile(`(".*?"|\w)\s*\:\s*(.*?)(\s*,|\s*\})`)
 	values = r.FindAllStringSubmatch(values[0][1], -1)
 	if len(values) == 0 {
@@ -376,7 +357,7 @@ func fetchFileURL(shareURL string) (string, error) {
 
 	u := endpoint + "/ajaxm.php"
 	body := form.Encode()
-	log.Printf("request ajaxm url:%v body: %v", u, body)
+	logger.Debugln("request ajaxm url:%v body: %v", u, body)
 	raw, err = util.POST(u, util.WithBody(body), util.WithRetry(3),
 		util.WithHeader(map[string]string{
 			"Content-Type":   "application/x-www-form-urlencoded",
@@ -406,7 +387,7 @@ func fetchFileURL(shareURL string) (string, error) {
 }
 
 func LanZouRealURL(download string) (string, error) {
-	raw, header, err := util.Request("GET", download)
+	raw, header, err := util.Request("GET", download, util.WithCharset(nil, "gb18030"))
 	if err != nil {
 		return "", fmt.Errorf("get download url failed: %w", err)
 	}
@@ -419,7 +400,7 @@ func LanZouRealURL(download string) (string, error) {
 	// 正常流量
 	if !strings.Contains(string(raw), "网络异常") {
 		uRL := header.Get("Location")
-		log.Printf("url: %v", uRL)
+		logger.Infoln("url: %v", uRL)
 		return uRL, nil
 	}
 
@@ -440,7 +421,7 @@ func LanZouRealURL(download string) (string, error) {
 
 	// key, value
 	values[0][1] = strings.ReplaceAll(values[0][1], `'`, `"`)
-	log.Printf("ajax regex data: %v", values[0][1])
+	logger.Debugln("ajax regex data: %v", values[0][1])
 	r = regexp.MustCompile(`(".*?"|\w)\s*\:\s*(.*?)(\s*,|\s*\})`)
 	values = r.FindAllStringSubmatch(values[0][1], -1)
 	if len(values) == 0 {
@@ -487,23 +468,12 @@ func LanZouRealURL(download string) (string, error) {
 
 	u := "https://developer.lanzoug.com/file/ajax.php"
 	body := form.Encode()
-	host := func(cur string) (val string) {
-		name := cur[:strings.Index(cur, ".")]
-		for i, v := range hosts {
-			if strings.HasSuffix(cur, v) && i < len(hosts)-1 {
-				return name + "." + hosts[i+1]
-			}
-		}
-
-		return name + "." + hosts[0]
-	}
-
-	log.Printf("request ajax url:%v body: %v", u, body)
+	logger.Debugln("request ajax url:%v body: %v", u, body)
 	raw, err = util.POST(u, util.WithBody(body), util.WithRetry(4),
 		util.WithHeader(map[string]string{
 			"Content-Type":   "application/x-www-form-urlencoded",
 			"Content-Length": fmt.Sprintf("%v", len(body)),
-		}), util.WithRandomHost(host))
+		}), util.WithMirror(devMirror, nil))
 	if err != nil {
 		return "", fmt.Errorf("request ajax failed: %w", err)
 	}
@@ -3,7 +3,6 @@ package speech
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/url"
 	"os"
@@ -455,7 +454,7 @@ func applyDescrambler(streamingData string) {
 			continue
 		}
 
-		log.Printf("url=%v need signature", u)
+		logger.Warnln("url=%v need signature", u)
 	}
 }
 
@@ -758,7 +757,7 @@ func (f *Format) Download(dst string) error {
 
 	size := f.FileSize
 	download := int64(0)
-	log.Printf("start download audio file: %v", f.Url)
+	logger.Infoln("start download audio file: %v", f.Url)
 	for size == 0 || download < size {
 		bytes := fileSize - rand.Int31n(fileSize*0.05)
 		stopPos := download + int64(bytes)
@@ -793,7 +792,7 @@ func (f *Format) Download(dst string) error {
 		}
 
 		download = stopPos
-		log.Printf("current: %v, size: %v", download, size)
+		logger.Debugln("current: %v, size: %v", download, size)
 	}
 
 	return nil
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// textContentType 判断响应是否为文本内容, 二进制内容不做转码
+func textContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, v := range []string{"html", "xml", "json", "javascript", "x-www-form-urlencoded"} {
+		if strings.Contains(mediaType, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeCharset 根据 BOM, Content-Type 以及 <meta charset> 检测字符集, 并将 raw 转码为 UTF-8.
+// 无法检测时使用 fallback, fallback 为空则不转码. 返回转码之后的内容和字符集名称.
+func DecodeCharset(raw []byte, contentType, fallback string) ([]byte, string, error) {
+	if !textContentType(contentType) {
+		return raw, "", nil
+	}
+
+	enc, name, certain := charset.DetermineEncoding(raw, contentType)
+	// DetermineEncoding 无法检测时默认返回 windows-1252
+	if !certain && name == "windows-1252" {
+		if fallback == "" {
+			return raw, "", nil
+		}
+		enc, name = charset.Lookup(fallback)
+		if enc == nil {
+			return raw, "", nil
+		}
+	}
+
+	if name == "utf-8" || enc == encoding.Nop {
+		return bytes.TrimPrefix(raw, utf8BOM), "utf-8", nil
+	}
+
+	val, err := enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		return raw, name, err
+	}
+	return val, name, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"context"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
@@ -12,7 +13,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -20,6 +20,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/tiechui1994/tool/log"
 )
 
 type cachedData struct {
@@ -28,22 +30,6 @@ type cachedData struct {
 	Header http.Header
 }
 
-type CodeError struct {
-	Method  string
-	URL     string
-	Code    int
-	Message string
-}
-
-func (err CodeError) Error() string {
-	if err.Message == "" {
-		return fmt.Sprintf("%v %q : status: %v", err.Method, err.URL,
-			http.StatusText(err.Code))
-	}
-	return fmt.Sprintf("%v %q : (status:%v, message:%v)", err.Method, err.URL,
-		http.StatusText(err.Code), err.Message)
-}
-
 func Request(method, u string, opts ...Option) (json.RawMessage, http.Header, error) {
 	return globalClient.Request(method, u, opts...)
 }
@@ -88,26 +74,6 @@ func drainBody(b io.Reader) (r1, r2 io.Reader, err error) {
 	return &buf, bytes.NewReader(buf.Bytes()), nil
 }
 
-func (c *EmbedClient) dumpRequest(req *http.Request, now time.Time) {
-	uv := req.URL
-	method := req.Method
-
-	prefix := uv.Path[strings.LastIndex(uv.Path, "/")+1:]
-	key := fmt.Sprintf("%v_%v_%v_REQ.txt", prefix, strings.ToUpper(method), now.Format("150405999"))
-	raw, _ := httputil.DumpRequestOut(req, true)
-	_ = os.WriteFile(filepath.Join(c.config.dir, key), raw, 0644)
-}
-
-func (c *EmbedClient) dumpResponse(req *http.Request, resp *http.Response, now time.Time) {
-	uv := req.URL
-	method := req.Method
-
-	prefix := uv.Path[strings.LastIndex(uv.Path, "/")+1:]
-	key := fmt.Sprintf("%v_%v_%v_RESP.txt", prefix, strings.ToUpper(method), now.Format("150405999"))
-	raw, _ := httputil.DumpResponse(resp, true)
-	_ = os.WriteFile(filepath.Join(c.config.dir, key), raw, 0644)
-}
-
 func (c *EmbedClient) cacheRequest(req *http.Request) *cachedData {
 	key := hex.EncodeToString(MD5(req.Method + "_" + req.URL.String()))
 	testCacheFile := filepath.Join(c.config.dir, key)
@@ -131,19 +97,130 @@ func hasBody(method string) bool {
 	return method == http.MethodPut || method == http.MethodPost || method == http.MethodDelete || method == http.MethodPatch
 }
 
-func (c *EmbedClient) Request(method, u string, opts ...Option) (json.RawMessage, http.Header, error) {
-	c.init()
-
+// options 创建请求参数, 未设置的参数使用 client 的默认值
+func (c *EmbedClient) options(opts []Option) *httpOptions {
 	options := defaultOptions()
+	options.retry = c.config.retry
 	for _, opt := range opts {
 		opt.apply(options)
 	}
+	c.withRequestID(options)
+	return options
+}
+
+// httpClient 返回请求使用的 client, 请求级别的代理和本地地址需要 clone transport
+func (c *EmbedClient) httpClient(options *httpOptions) *http.Client {
+	client := c.Client
+	if options.proxy == nil && options.proxyDail == nil && options.bind == nil {
+		return client
+	}
+
+	var clone *http.Transport
+	var config *clientConfig
+	switch transport := client.Transport.(type) {
+	case *http.Transport:
+		clone = transport.Clone()
+	case *customerTransport:
+		clone = transport.Transport.(*http.Transport).Clone()
+		config = transport.config
+	default:
+		return client
+	}
+
+	if options.bind != nil {
+		clone.DialContext = c.dialContext(options.bind)
+	}
+	if options.proxy != nil {
+		clone.Proxy = options.proxy
+	} else if options.proxyDail != nil {
+		clone.Proxy = nil
+		clone.DialContext = options.proxyDail
+	}
+
+	if config != nil {
+		return &http.Client{Transport: &customerTransport{Transport: clone, config: config}}
+	}
+	return &http.Client{Transport: clone}
+}
+
+func (c *EmbedClient) Request(method, u string, opts ...Option) (json.RawMessage, http.Header, error) {
+	c.init()
+
+	options := c.options(opts)
+
+	if options.coalesce && options.coalescible() && (method == http.MethodGet || method == http.MethodHead) {
+		return c.coalesceRequest(method, u, options)
+	}
+	return c.request(method, u, options)
+}
+
+// coalesceRequest 合并相同的并发请求, 所有调用者共享第一个请求的结果. 共享的请求使用第一个调用者的 ctx,
+// 第一个调用者取消时, 其他调用者各自重新请求.
+func (c *EmbedClient) coalesceRequest(method, u string, options *httpOptions) (json.RawMessage, http.Header, error) {
+	// 每个调用者的 WithTimeout 各自生效, request 不再重复设置
+	defer options.withTimeout()()
+	options.timeout = 0
+
+	key := []string{method, u}
+	if options.tokenSource != nil {
+		token, err := options.tokenSource.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key = append(key, "Authorization: "+token.Type()+" "+token.AccessToken)
+	}
+	for _, name := range append([]string{"Authorization", "Cookie"}, options.coalesceKeys...) {
+		for k, v := range options.header {
+			if strings.EqualFold(k, name) {
+				key = append(key, http.CanonicalHeaderKey(k)+": "+v)
+			}
+		}
+	}
+
+	result := c.group.DoChan(strings.Join(key, "\n"), func() (interface{}, error) {
+		raw, header, err := c.request(method, u, options)
+		return cachedData{Raw: raw, Header: header}, err
+	})
+
+	select {
+	case <-options.ctx.Done():
+		return nil, nil, options.ctx.Err()
+	case val := <-result:
+		if val.Err != nil && val.Shared && options.ctx.Err() == nil &&
+			(errors.Is(val.Err, context.Canceled) || errors.Is(val.Err, context.DeadlineExceeded)) {
+			return c.request(method, u, options)
+		}
+		data, _ := val.Val.(cachedData)
+		raw := append(json.RawMessage(nil), data.Raw...)
+		return raw, data.Header.Clone(), val.Err
+	}
+}
+
+func (c *EmbedClient) request(method, u string, options *httpOptions) (json.RawMessage, http.Header, error) {
+	defer options.withTimeout()()
+
+	// mirror hosts
+	var mirrors []string
+	var origin = u
+	if options.mirror != nil {
+		uRL, err := url.Parse(u)
+		if err != nil {
+			return nil, nil, err
+		}
+		// host 不属于镜像时只请求原始 host, 不能替换为镜像(无法保留子域名)
+		if options.mirror.contains(uRL.Host) {
+			mirrors = options.mirror.order(uRL.Host)
+		}
+		if len(mirrors) > 0 && options.retry < len(mirrors)-1 {
+			options.retry = len(mirrors) - 1
+		}
+	}
 
 	// dump body reader
 	var err error
 	var body = options.body
 	var dump io.Reader
-	if options.retry > 0 && hasBody(method) {
+	if (options.retry > 0 || options.tokenSource != nil) && hasBody(method) {
 		body, dump, err = drainBody(body)
 		if err != nil {
 			return nil, nil, err
@@ -151,23 +228,31 @@ func (c *EmbedClient) Request(method, u string, opts ...Option) (json.RawMessage
 	}
 
 	try := 0
+	replayed := false
+	stop, cancel := func() bool { return false }, context.CancelFunc(func() {})
+	defer func() { cancel() }()
 	for try <= options.retry {
-		if try > 0 {
-			if options.randomHost != nil {
-				uRL, _ := url.Parse(u)
-				uRL.Host = options.randomHost(uRL.Host)
-				u = uRL.String()
-			}
+		cancel()
+		var ctx context.Context
+		ctx, stop, cancel = options.attemptContext()
+
+		if try > 0 && options.randomHost != nil {
+			uRL, _ := url.Parse(u)
+			uRL.Host = options.randomHost(uRL.Host)
+			u = uRL.String()
+		}
 
-			// dump dump reader
-			if hasBody(method) {
-				body, dump, err = drainBody(dump)
-				if err != nil {
-					return nil, nil, err
-				}
+		// dump dump reader
+		if (try > 0 || replayed) && hasBody(method) {
+			body, dump, err = drainBody(dump)
+			if err != nil {
+				return nil, nil, err
 			}
 		}
-		request, err := http.NewRequestWithContext(options.ctx, method, u, body)
+		if len(mirrors) > 0 {
+			u = options.mirror.rewrite(origin, mirrors[try%len(mirrors)])
+		}
+		request, err := http.NewRequestWithContext(ctx, method, u, body)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -182,35 +267,17 @@ func (c *EmbedClient) Request(method, u string, opts ...Option) (json.RawMessage
 			request.ContentLength, _ = strconv.ParseInt(val, 10, 64)
 		}
 
-		client := c.Client
-		if options.proxy != nil {
-			switch transport := client.Transport.(type) {
-			case *http.Transport:
-				clone := transport.Clone()
-				clone.Proxy = options.proxy
-				client = &http.Client{Transport: clone}
-			case *customerTransport:
-				clone := transport.Transport.(*http.Transport).Clone()
-				clone.Proxy = options.proxy
-				transport.Transport = clone
-				client = &http.Client{Transport: transport}
-			}
-		} else if options.proxyDail != nil {
-			switch transport := client.Transport.(type) {
-			case *http.Transport:
-				clone := transport.Clone()
-				clone.Proxy = nil
-				clone.DialContext = options.proxyDail
-				client = &http.Client{Transport: clone}
-			case *customerTransport:
-				clone := transport.Transport.(*http.Transport).Clone()
-				clone.Proxy = nil
-				clone.DialContext = options.proxyDail
-				transport.Transport = clone
-				client = &http.Client{Transport: transport}
+		var token Token
+		if options.tokenSource != nil {
+			token, err = options.tokenSource.Token()
+			if err != nil {
+				return nil, nil, err
 			}
+			request.Header.Set("Authorization", token.Type()+" "+token.AccessToken)
 		}
 
+		client := c.httpClient(options)
+
 		if options.cached {
 			if v := c.cacheRequest(request); v != nil {
 				if options.cachedPeriod < 0 || time.Now().Unix()-v.Date < options.cachedPeriod*1000 {
@@ -227,17 +294,38 @@ func (c *EmbedClient) Request(method, u string, opts ...Option) (json.RawMessage
 		if options.dump {
 			c.dumpRequest(request, now)
 		}
+		if options.logger.Enabled(log.DebugLevel) {
+			if command, err := c.curl(request, options); err == nil {
+				options.logger.Debugln("%v", command)
+			}
+		}
 
+		if err = c.config.limiter.wait(ctx); err != nil {
+			return nil, nil, err
+		}
 		response, err := client.Do(request)
 		if err != nil {
+			err = options.attemptError(err, stop())
+			if options.mirror != nil && IsRetryable(err) {
+				options.mirror.markFailure(u)
+			}
 			if IsRetryable(err) && try < options.retry {
 				try++
-				time.Sleep(time.Second * time.Duration(try))
-				continue
+				if wait(options.ctx, try) {
+					continue
+				}
 			}
 			return nil, nil, err
 		}
 
+		if options.mirror != nil {
+			if IsRetryable(CodeError{Method: method, URL: u, Code: response.StatusCode}) {
+				options.mirror.markFailure(u)
+			} else {
+				options.mirror.markSuccess(u, time.Since(now))
+			}
+		}
+
 		if options.dump {
 			c.dumpResponse(request, response, now)
 		}
@@ -278,31 +366,51 @@ func (c *EmbedClient) Request(method, u string, opts ...Option) (json.RawMessage
 			return raw, response.Header, nil
 		}()
 
-		if err != nil {
+		if err = options.attemptError(err, stop()); err != nil {
 			if IsRetryable(err) && try < options.retry {
 				try++
-				time.Sleep(time.Second * time.Duration(try))
-				continue
+				if wait(options.ctx, try) {
+					continue
+				}
 			}
 			return nil, nil, err
 		}
 
+		if options.charset {
+			var name string
+			raw, name, err = DecodeCharset(raw, headers.Get("Content-Type"), options.charsetBack)
+			if err != nil {
+				return raw, headers, err
+			}
+			if options.charsetFound != nil {
+				options.charsetFound(name)
+			}
+		}
+
 		if options.cached {
 			c.cacheResponse(request, cachedData{time.Now().Unix(), raw, headers})
 		}
 
 		if response.StatusCode >= 400 {
-			if IsRetryable(CodeError{method, u, response.StatusCode, ""}) && try < options.retry {
-				try++
-				time.Sleep(time.Second * time.Duration(try))
-				continue
+			// token 失效, 刷新之后重放一次
+			if response.StatusCode == http.StatusUnauthorized && options.tokenSource != nil && !replayed {
+				replayed = true
+				if _, err = options.tokenSource.refreshFrom(token.AccessToken); err == nil {
+					continue
+				}
 			}
-			if strings.Contains(response.Header.Get("content-type"), "text/html") {
-				return raw, headers, CodeError{method, u, response.StatusCode, ""}
+			if IsRetryable(CodeError{Method: method, URL: u, Code: response.StatusCode}) && try < options.retry {
+				try++
+				if wait(options.ctx, try) {
+					continue
+				}
 			}
-			return raw, headers, CodeError{method, u, response.StatusCode, string(raw)}
+			return raw, headers, newCodeError(method, u, response, raw, try+1)
 		}
 
+		if options.mirrorSelect != nil {
+			options.mirrorSelect(u)
+		}
 		return raw, headers, nil
 	}
 
@@ -342,15 +450,21 @@ func (c *EmbedClient) HEAD(u string, opts ...Option) (raw json.RawMessage, err e
 func (c *EmbedClient) File(u, method string, opts ...Option) (io io.Reader, err error) {
 	c.init()
 
-	options := defaultOptions()
-	for _, opt := range opts {
-		opt.apply(options)
-	}
+	options := c.options(opts)
+	// 总超时覆盖 body 的读取, 在 body 关闭时释放
+	timeout := options.withTimeout()
+	defer func() {
+		if err != nil {
+			timeout()
+		}
+	}()
 
 	try := 0
 	for try <= options.retry {
-		request, err := http.NewRequestWithContext(options.ctx, method, u, options.body)
+		ctx, stop, cancel := options.attemptContext()
+		request, err := http.NewRequestWithContext(ctx, method, u, options.body)
 		if err != nil {
+			cancel()
 			return nil, err
 		}
 		for k, v := range options.header {
@@ -358,28 +472,40 @@ func (c *EmbedClient) File(u, method string, opts ...Option) (io io.Reader, err
 		}
 		request.Header.Set("User-Agent", hashUserAgent(u))
 
-		response, err := c.Do(request)
+		if err = c.config.limiter.wait(ctx); err != nil {
+			cancel()
+			return nil, err
+		}
+		response, err := c.httpClient(options).Do(request)
+		err = options.attemptError(err, stop())
 		if err != nil {
+			cancel()
 			if IsRetryable(err) && try < options.retry {
 				try++
-				time.Sleep(time.Second * time.Duration(try))
-				continue
+				if wait(options.ctx, try) {
+					continue
+				}
 			}
 			return nil, err
 		}
 
 		if response.StatusCode >= 400 {
-			err = CodeError{method, u, response.StatusCode, ""}
+			err = newCodeError(method, u, response, nil, try+1)
 			response.Body.Close()
+			cancel()
 			if IsRetryable(err) && try < options.retry {
 				try++
-				time.Sleep(time.Second * time.Duration(try))
-				continue
+				if wait(options.ctx, try) {
+					continue
+				}
 			}
 			return nil, err
 		}
 
-		return response.Body, nil
+		return &cancelBody{ReadCloser: response.Body, cancel: func() {
+			cancel()
+			timeout()
+		}}, nil
 	}
 
 	return nil, fmt.Errorf("max retries exceeded")
@@ -390,7 +516,7 @@ func IsRetryable(err error) bool {
 		return false
 	}
 
-	if val, ok := err.(CodeError); ok {
+	if val, ok := asCodeError(err); ok {
 		return val.Code == http.StatusBadGateway || val.Code == http.StatusServiceUnavailable ||
 			val.Code == http.StatusGatewayTimeout
 	}
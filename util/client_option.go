@@ -13,6 +13,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/tiechui1994/tool/aliyun/singleflight"
 	"golang.org/x/net/http2"
 )
 
@@ -32,6 +33,16 @@ type clientConfig struct {
 	cookieFun CustomerCookie
 	dir       string        // file jar dir
 	sync      chan struct{} // sync file jar
+
+	dump    *dumpConfig   // dump redact and retention
+	bind    *bindConfig   // local address and ip family
+	resolve *resolveTable // static host address
+
+	retry   int          // default request retry
+	limiter *rateLimiter // request rate limit
+	tls     *tls.Config
+
+	requestIDHeader string // 发送请求 ID 的 header, 为空时不发送
 }
 
 type ClientOption interface {
@@ -105,6 +116,91 @@ func WithConnTimeout(timeout, longTimeout time.Duration) ClientOption {
 	})
 }
 
+// WithDumpRedact 在默认规则的基础上追加 dump 时需要隐藏的 header, query 参数以及 body 字段
+func WithDumpRedact(headers, query, fields []string) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.dump.redactor.add(headers, query, fields)
+	})
+}
+
+// WithDumpBodyLimit dump 时 body 最多记录 limit 字节, 小于 0 表示不限制
+func WithDumpBodyLimit(limit int64) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.dump.bodyLimit = limit
+	})
+}
+
+// WithDumpRetention dump 文件最多保留 maxFiles 个, 最长保留 maxAge, 0 表示不限制
+func WithDumpRetention(maxFiles int, maxAge time.Duration) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.dump.maxFiles = maxFiles
+		config.dump.maxAge = maxAge
+	})
+}
+
+// WithClientLocalAddr 连接使用的本地地址, 多个地址时轮流使用, 远端地址只使用同协议的本地地址
+func WithClientLocalAddr(addrs ...string) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.bind = config.bind.merge(&bindConfig{localAddrs: parseLocalAddrs(addrs)})
+	})
+}
+
+// WithClientInterface 连接使用网卡 name 上的地址
+func WithClientInterface(name string) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.bind = config.bind.merge(&bindConfig{iface: name})
+	})
+}
+
+// WithClientIPFamily 连接优先使用或者只使用 IPv4/IPv6
+func WithClientIPFamily(family IPFamily) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.bind = config.bind.merge(&bindConfig{family: family})
+	})
+}
+
+// WithClientResolve 添加 host 到地址的静态映射, 格式为 "HOST:PORT:ADDR[,ADDR]", 在 DNS 之前查询.
+// PORT 为 "*" 表示任意端口, HOST 为 "*.domain" 匹配子域名, 为 "*" 匹配任意 host. 使用代理时映射的是代理的地址.
+func WithClientResolve(entries ...string) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		if config.resolve == nil {
+			config.resolve = newResolveTable()
+		}
+		config.resolve.add(entries...)
+	})
+}
+
+// WithClientRetry 请求默认的重试次数, WithRetry 可以覆盖
+func WithClientRetry(retry uint) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.retry = int(retry)
+	})
+}
+
+// WithClientRateLimit 限制每秒最多 rate 个请求(包括重试), 允许 burst 个突发请求. rate <= 0 表示不限制.
+func WithClientRateLimit(rate float64, burst int) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.limiter = nil
+		if rate > 0 {
+			config.limiter = newRateLimiter(rate, burst)
+		}
+	})
+}
+
+// WithClientTLS 设置 TLS 配置, 默认不校验证书
+func WithClientTLS(tlsConfig *tls.Config) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.tls = tlsConfig
+	})
+}
+
+// WithClientRequestIDHeader 发送请求 ID 使用的 header, 默认 DefaultRequestIDHeader, 为空时不发送(日志中仍然有请求 ID)
+func WithClientRequestIDHeader(name string) ClientOption {
+	return newFuncClientOption(func(config *clientConfig) {
+		config.requestIDHeader = name
+	})
+}
+
 func WithClientCookieJar(name string) ClientOption {
 	return newFuncClientOption(func(config *clientConfig) {
 		if config.cookieFun != nil {
@@ -248,8 +344,10 @@ func WithInitClientCookie(name, cookie, endpoint string) ClientOption {
 
 type EmbedClient struct {
 	*http.Client
-	once   sync.Once
-	config *clientConfig
+	once     sync.Once
+	config   *clientConfig
+	resolver *net.Resolver
+	group    singleflight.Group // coalesce request
 }
 
 func NewClient(opts ...ClientOption) *EmbedClient {
@@ -263,6 +361,15 @@ func NewClient(opts ...ClientOption) *EmbedClient {
 		dnsTimeout:      globalClient.config.dnsTimeout,
 		connTimeout:     globalClient.config.connTimeout,
 		connLongTimeout: globalClient.config.connLongTimeout,
+
+		dump:    globalClient.config.dump.clone(),
+		bind:    globalClient.config.bind,
+		resolve: globalClient.config.resolve.clone(),
+		retry:   globalClient.config.retry,
+		limiter: globalClient.config.limiter.clone(), // 每个 client 单独限速
+		tls:     globalClient.config.tls,
+
+		requestIDHeader: globalClient.config.requestIDHeader,
 	}
 
 	for _, opt := range opts {
@@ -273,7 +380,7 @@ func NewClient(opts ...ClientOption) *EmbedClient {
 
 func (c *EmbedClient) init() {
 	c.once.Do(func() {
-		resolver := &net.Resolver{
+		c.resolver = &net.Resolver{
 			PreferGo: true, // 表示使用 Go 的 DNS
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
 				d := net.Dialer{Timeout: c.config.dnsTimeout}
@@ -284,26 +391,9 @@ func (c *EmbedClient) init() {
 		}
 
 		transport := &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				var resolve *net.Resolver
-				if len(c.config.dns) > 0 {
-					resolve = resolver
-				}
-				d := net.Dialer{
-					Resolver:  resolve,
-					Timeout:   c.config.dialerTimeout,
-					KeepAlive: c.config.dialerKeepAlive,
-				}
-				conn, err := d.DialContext(ctx, network, addr)
-				if err != nil {
-					return nil, err
-				}
-				return newTimeoutConn(conn, c.config.connTimeout, c.config.connLongTimeout), nil
-			},
-			DisableKeepAlives: true,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+			DialContext:         c.dialContext(nil),
+			DisableKeepAlives:   true,
+			TLSClientConfig:     c.tlsConfig(),
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 100,
 			Proxy: func(req *http.Request) (*url.URL, error) {
@@ -328,6 +418,15 @@ func (c *EmbedClient) init() {
 	})
 }
 
+func (c *EmbedClient) tlsConfig() *tls.Config {
+	if c.config.tls != nil {
+		return c.config.tls.Clone()
+	}
+	return &tls.Config{
+		InsecureSkipVerify: true,
+	}
+}
+
 func (c *EmbedClient) GetCookie(url *url.URL, name string) *http.Cookie {
 	if c.config.cookieFun == nil && c.config.cookieJar == nil {
 		return nil
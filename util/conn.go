@@ -31,7 +31,7 @@ func (c *timeoutConn) Read(b []byte) (n int, err error) {
 func (c *timeoutConn) Write(b []byte) (n int, err error) {
 	c.SetWriteDeadline(time.Now().Add(c.timeout))
 	n, err = c.conn.Write(b)
-	c.SetReadDeadline(time.Now().Add(c.longTimeout))
+	c.SetWriteDeadline(time.Now().Add(c.longTimeout))
 	return n, err
 }
 
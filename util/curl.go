@@ -0,0 +1,448 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// curl 参数中需要值但是不影响请求的选项
+var curlSkipWithValue = map[string]bool{
+	"-o": true, "--output": true,
+	"-m": true, "--max-time": true,
+	"--connect-timeout": true,
+	"-w":                true, "--write-out": true,
+	"-c": true, "--cookie-jar": true,
+	"--retry": true,
+	"-r":      true, "--range": true,
+}
+
+// ParseCurl 将 curl 命令行(例如浏览器 "Copy as cURL")转换为 method, url 和请求参数.
+// 支持 header, body, cookie, proxy, user, user-agent, referer. EmbedClient 默认不校验证书, -k 被忽略.
+func ParseCurl(command string) (method, u string, opts []Option, err error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return method, u, nil, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return method, u, nil, errors.New("not curl command")
+	}
+
+	header := make(map[string]string)
+	var data []string
+	var proxy string
+	var get, head bool
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if strings.HasPrefix(arg, "--") {
+				if idx := strings.IndexByte(arg, '='); idx > 0 {
+					val := arg[idx+1:]
+					arg = arg[:idx]
+					return val, nil
+				}
+			} else if len(arg) > 2 {
+				val := arg[2:]
+				arg = arg[:2]
+				return val, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option %v requires value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+
+		if !strings.HasPrefix(arg, "-") {
+			u = arg
+			continue
+		}
+
+		name := arg
+		if strings.HasPrefix(arg, "--") {
+			if idx := strings.IndexByte(arg, '='); idx > 0 {
+				name = arg[:idx]
+			}
+		} else if len(arg) > 2 {
+			name = arg[:2]
+		}
+
+		var val string
+		switch name {
+		case "-X", "--request":
+			if method, err = value(); err != nil {
+				return
+			}
+		case "--url":
+			if u, err = value(); err != nil {
+				return
+			}
+		case "-H", "--header":
+			if val, err = value(); err != nil {
+				return
+			}
+			kv := strings.SplitN(val, ":", 2)
+			if len(kv) == 2 {
+				header[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii":
+			if val, err = value(); err != nil {
+				return
+			}
+			data = append(data, val)
+		case "--data-urlencode":
+			if val, err = value(); err != nil {
+				return
+			}
+			if idx := strings.IndexByte(val, '='); idx >= 0 {
+				val = val[:idx+1] + url.QueryEscape(val[idx+1:])
+			} else {
+				val = url.QueryEscape(val)
+			}
+			data = append(data, val)
+		case "-b", "--cookie":
+			if val, err = value(); err != nil {
+				return
+			}
+			// 没有 "=" 表示 cookie 文件
+			if strings.Contains(val, "=") {
+				header["Cookie"] = val
+			}
+		case "-A", "--user-agent":
+			if header["User-Agent"], err = value(); err != nil {
+				return
+			}
+		case "-e", "--referer":
+			if header["Referer"], err = value(); err != nil {
+				return
+			}
+		case "-u", "--user":
+			if val, err = value(); err != nil {
+				return
+			}
+			header["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(val))
+		case "-x", "--proxy":
+			if proxy, err = value(); err != nil {
+				return
+			}
+		case "-G", "--get":
+			get = true
+		case "-I", "--head":
+			head = true
+		default:
+			if curlSkipWithValue[name] && name == arg {
+				i++
+			}
+		}
+	}
+
+	if u == "" {
+		return method, u, nil, errors.New("curl command without url")
+	}
+
+	body := strings.Join(data, "&")
+	switch {
+	case get && body != "":
+		if strings.Contains(u, "?") {
+			u += "&" + body
+		} else {
+			u += "?" + body
+		}
+		body = ""
+	case head && method == "":
+		method = http.MethodHead
+	}
+	if method == "" {
+		method = http.MethodGet
+		if body != "" {
+			method = http.MethodPost
+		}
+	}
+
+	if body != "" {
+		if !hasHeader(header, "Content-Type") {
+			header["Content-Type"] = "application/x-www-form-urlencoded"
+		}
+		opts = append(opts, WithBody(body))
+	}
+	if len(header) > 0 {
+		opts = append(opts, WithHeader(header))
+	}
+	if proxy != "" {
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return method, u, nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		opts = append(opts, WithProxy(http.ProxyURL(proxyURL)))
+	}
+
+	return method, u, opts, nil
+}
+
+// splitCommand 按照 shell 规则分割命令行, 支持单引号, 双引号, $'...' 以及续行
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var buf strings.Builder
+	inArg := false
+	flush := func() {
+		if inArg {
+			args = append(args, buf.String())
+			buf.Reset()
+			inArg = false
+		}
+	}
+
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case ch == '\\' && i+1 < len(command) && (command[i+1] == '\n' || command[i+1] == '\r'):
+			i++
+			if command[i] == '\r' && i+1 < len(command) && command[i+1] == '\n' {
+				i++
+			}
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			flush()
+		case ch == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			buf.WriteString(command[i+1 : i+1+end])
+			inArg = true
+			i += end + 1
+		case ch == '$' && i+1 < len(command) && command[i+1] == '\'':
+			n, err := ansiQuoted(command[i+2:], &buf)
+			if err != nil {
+				return nil, err
+			}
+			inArg = true
+			i += n + 1
+		case ch == '"':
+			inArg = true
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("$`\"\\\n", command[i+1]) >= 0 {
+					i++
+					if command[i] == '\n' {
+						continue
+					}
+				}
+				buf.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, errors.New("unterminated double quote")
+			}
+		case ch == '\\' && i+1 < len(command):
+			i++
+			buf.WriteByte(command[i])
+			inArg = true
+		default:
+			buf.WriteByte(ch)
+			inArg = true
+		}
+	}
+	flush()
+
+	return args, nil
+}
+
+// ansiQuoted 解析 $'...' 的内容, 返回消耗的字节数(包括结束的单引号)
+func ansiQuoted(s string, buf *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			return i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return 0, errors.New("unterminated ansi quote")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case 'x':
+				if i+2 < len(s) {
+					if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+						buf.WriteByte(byte(v))
+						i += 2
+						continue
+					}
+				}
+				buf.WriteByte('x')
+			case 'u':
+				if i+4 < len(s) {
+					if v, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+						buf.WriteRune(rune(v))
+						i += 4
+						continue
+					}
+				}
+				buf.WriteByte('u')
+			default:
+				buf.WriteByte(s[i])
+			}
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return 0, errors.New("unterminated ansi quote")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Curl 将请求转换为可以复现的 curl 命令, 请求 body 通过 GetBody 读取, 不影响请求本身
+func Curl(request *http.Request) (string, error) {
+	return curlCommand(request, nil)
+}
+
+func curlCommand(request *http.Request, proxy *url.URL) (string, error) {
+	values := []string{"curl"}
+	if request.Method != http.MethodGet {
+		values = append(values, "-X", request.Method)
+	}
+
+	keys := make([]string, 0, len(request.Header))
+	for k := range request.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range request.Header[k] {
+			values = append(values, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	if request.GetBody != nil && request.ContentLength != 0 {
+		body, err := request.GetBody()
+		if err != nil {
+			return "", err
+		}
+		raw, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+		values = append(values, "--data-binary", shellQuote(string(raw)))
+	}
+
+	if proxy != nil {
+		values = append(values, "-x", shellQuote(proxy.String()))
+	}
+	values = append(values, shellQuote(request.URL.String()))
+
+	return strings.Join(values, " "), nil
+}
+
+// curl 记录 EmbedClient 的请求, 包括 cookie jar 中的 cookie 和请求使用的代理. 敏感信息按照 dump 规则隐藏.
+func (c *EmbedClient) curl(request *http.Request, options *httpOptions) (string, error) {
+	body, err := c.curlBody(request)
+	if err != nil {
+		return "", err
+	}
+
+	clone := request.Clone(request.Context())
+	if clone.Header.Get("Cookie") == "" {
+		var cookies []string
+		for _, cookie := range c.GetCookies(request.URL) {
+			cookies = append(cookies, cookie.Name+"="+cookie.Value)
+		}
+		if len(cookies) > 0 {
+			clone.Header.Set("Cookie", strings.Join(cookies, "; "))
+		}
+	}
+
+	var proxy *url.URL
+	if options.proxy != nil {
+		proxy, _ = options.proxy(request)
+	} else if c.config.proxy != nil {
+		proxy, _ = c.config.proxy(request)
+	}
+
+	redactor := c.config.dump.redactor
+	clone.URL = redactor.URL(request.URL)
+	clone.Header = redactor.Header(clone.Header)
+	clone.ContentLength = int64(len(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	if proxy != nil {
+		proxy = redactor.URL(proxy)
+	}
+
+	return curlCommand(clone, proxy)
+}
+
+// curlBody 返回 curl 命令中的 body. 不能重放的 body 不读取, 二进制或者超过 dump 大小限制的 body 使用占位符,
+// 例如 "@body(1024 bytes, binary)", 文本 body 按照 dump 规则隐藏敏感信息.
+func (c *EmbedClient) curlBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+
+	config := c.config.dump
+	placeholder := func(reason string) []byte {
+		// body 不为空时 ContentLength 为 0 表示长度未知
+		if request.ContentLength <= 0 {
+			return []byte(fmt.Sprintf("@body(unknown size, %v)", reason))
+		}
+		return []byte(fmt.Sprintf("@body(%v bytes, %v)", request.ContentLength, reason))
+	}
+	if request.GetBody == nil {
+		return placeholder("not replayable"), nil
+	}
+	if config.bodyLimit >= 0 && request.ContentLength > config.bodyLimit {
+		return placeholder("truncated"), nil
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	reader := io.Reader(body)
+	if config.bodyLimit >= 0 {
+		reader = io.LimitReader(body, config.bodyLimit+1)
+	}
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if config.bodyLimit >= 0 && int64(len(raw)) > config.bodyLimit {
+		return placeholder("truncated"), nil
+	}
+	if !isText(raw) {
+		return placeholder("binary"), nil
+	}
+
+	return config.redactBody(raw, request.Header.Get("Content-Type"), int64(len(raw))), nil
+}
+
+// isText body 是否为可以直接输出的文本: UTF-8 并且不包含换行和制表符之外的控制字符
+func isText(raw []byte) bool {
+	if !utf8.Valid(raw) {
+		return false
+	}
+	for _, r := range string(raw) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
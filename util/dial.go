@@ -0,0 +1,246 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+)
+
+// IPFamily 连接使用的 IP 协议
+type IPFamily int
+
+const (
+	IPAny IPFamily = iota
+	IPv4Prefer
+	IPv6Prefer
+	IPv4Only
+	IPv6Only
+)
+
+// 本地地址轮转计数, 所有请求共享
+var bindNext uint32
+
+// bindConfig 连接绑定的本地地址(或者网卡)以及 IP 协议
+type bindConfig struct {
+	localAddrs []net.IP
+	iface      string
+	family     IPFamily
+}
+
+func (b *bindConfig) empty() bool {
+	return b == nil || (len(b.localAddrs) == 0 && b.iface == "" && b.family == IPAny)
+}
+
+// merge 请求级别的设置覆盖 client 的设置
+func (b *bindConfig) merge(o *bindConfig) *bindConfig {
+	val := &bindConfig{}
+	if b != nil {
+		*val = *b
+	}
+	if o == nil {
+		return val
+	}
+	if len(o.localAddrs) > 0 || o.iface != "" {
+		val.localAddrs = o.localAddrs
+		val.iface = o.iface
+	}
+	if o.family != IPAny {
+		val.family = o.family
+	}
+	return val
+}
+
+func parseLocalAddrs(addrs []string) []net.IP {
+	var val []net.IP
+	for _, v := range addrs {
+		if ip := net.ParseIP(v); ip != nil {
+			val = append(val, ip)
+		}
+	}
+	return val
+}
+
+// sources 本地地址池, 包括网卡上的地址
+func (b *bindConfig) sources() ([]net.IP, error) {
+	val := b.localAddrs
+	if b.iface == "" {
+		return val, nil
+	}
+
+	iface, err := net.InterfaceByName(b.iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			val = append(val, ipNet.IP)
+		}
+	}
+	if len(val) == 0 {
+		return nil, fmt.Errorf("interface %v has no address", b.iface)
+	}
+	return val, nil
+}
+
+// filter 按照 family 过滤和排序远端地址
+func (b *bindConfig) filter(ips []net.IP) []net.IP {
+	val := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		switch {
+		case b.family == IPv4Only && ip.To4() == nil:
+		case b.family == IPv6Only && ip.To4() != nil:
+		default:
+			val = append(val, ip)
+		}
+	}
+
+	if b.family == IPv4Prefer || b.family == IPv6Prefer {
+		sort.SliceStable(val, func(i, j int) bool {
+			return (val[i].To4() != nil) == (b.family == IPv4Prefer) && (val[j].To4() != nil) != (b.family == IPv4Prefer)
+		})
+	}
+	return val
+}
+
+// source 从地址池中轮转选择和 ip 同协议的本地地址, 地址池为空时返回 nil
+func source(pool []net.IP, ip net.IP) (net.IP, bool) {
+	if len(pool) == 0 {
+		return nil, true
+	}
+
+	var match []net.IP
+	for _, v := range pool {
+		if (v.To4() != nil) == (ip.To4() != nil) {
+			match = append(match, v)
+		}
+	}
+	if len(match) == 0 {
+		return nil, false
+	}
+	return match[int(atomic.AddUint32(&bindNext, 1)-1)%len(match)], true
+}
+
+// dial 按照 family 顺序逐个尝试 ips, 使用地址池中的本地地址建立连接
+func (b *bindConfig) dial(ctx context.Context, dialer *net.Dialer, host, port string, ips []net.IP) (net.Conn, error) {
+	ips = b.filter(ips)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no suitable address for %v", host)
+	}
+
+	pool, err := b.sources()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		local, ok := source(pool, ip)
+		if !ok {
+			lastErr = fmt.Errorf("no local address for %v", ip)
+			continue
+		}
+
+		d := *dialer
+		d.LocalAddr = nil
+		if local != nil {
+			d.LocalAddr = &net.TCPAddr{IP: local}
+		}
+		network := "tcp4"
+		if ip.To4() == nil {
+			network = "tcp6"
+		}
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("dial failed")
+	}
+	return nil, lastErr
+}
+
+// netResolver 设置 dns 时使用自定义的 resolver, 否则使用系统 resolver
+func (c *EmbedClient) netResolver() *net.Resolver {
+	if len(c.config.dns) > 0 {
+		return c.resolver
+	}
+	return nil
+}
+
+// dialContext 返回 transport 使用的 DialContext, bind 为请求级别的设置. 连接使用 timeoutConn 限制读写的时间.
+func (c *EmbedClient) dialContext(bind *bindConfig) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := c.dial(ctx, bind, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newTimeoutConn(conn, c.config.connTimeout, c.config.connLongTimeout), nil
+	}
+}
+
+// dial 建立连接, bind 为请求级别的设置.
+// 地址先查询静态映射(WithClientResolve), 之后查询 DNS. 只修改连接的地址, SNI 和 Host 不变.
+func (c *EmbedClient) dial(ctx context.Context, bind *bindConfig, network, addr string) (net.Conn, error) {
+	config := c.config.bind
+	if bind != nil {
+		config = config.merge(bind)
+	}
+	d := net.Dialer{
+		Resolver:  c.netResolver(),
+		Timeout:   c.config.dialerTimeout,
+		KeepAlive: c.config.dialerKeepAlive,
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, override := c.config.resolve.lookup(host, port)
+
+	var conn net.Conn
+	if config.empty() && !override {
+		conn, err = d.DialContext(ctx, network, addr)
+	} else {
+		if !override {
+			if ip := net.ParseIP(host); ip != nil {
+				ips = []net.IP{ip}
+			} else if ips, err = c.lookupIP(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+		if config == nil {
+			config = &bindConfig{}
+		}
+		conn, err = config.dial(ctx, &d, host, port, ips)
+	}
+	return conn, err
+}
+
+func (c *EmbedClient) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	resolver := c.netResolver()
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
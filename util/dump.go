@@ -0,0 +1,467 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redactMask = "***"
+
+	defaultDumpBodyLimit = 1024 * 1024
+	defaultDumpMaxFiles  = 500
+	defaultDumpMaxAge    = 7 * 24 * time.Hour
+	dumpPruneInterval    = time.Minute
+)
+
+var (
+	defaultRedactHeaders = []string{
+		"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+		"X-Signature", "X-Api-Key", "X-Csrf-Token", "X-Oss-Security-Token", "X-Oss-Callback",
+	}
+	defaultRedactQuery = []string{
+		"access_token", "refresh_token", "token", "key", "api_key", "apikey",
+		"sign", "signature", "x-oss-signature", "auth_key", "password", "pwd",
+	}
+	defaultRedactFields = []string{
+		"access_token", "refresh_token", "token", "id_token", "password", "pwd",
+		"secret", "client_secret", "authorization", "security_token", "access_key_secret", "signature",
+	}
+)
+
+// Redactor 隐藏 header, query 参数以及 JSON(或者 form) body 字段中的敏感信息.
+// 名称匹配不区分大小写, JSON 字段匹配任意层级.
+type Redactor struct {
+	headers map[string]bool
+	query   map[string]bool
+	fields  map[string]bool
+}
+
+// NewRedactor 创建 Redactor, 在默认规则(token, cookie, 签名等)的基础上追加规则
+func NewRedactor(headers, query, fields []string) *Redactor {
+	r := &Redactor{
+		headers: make(map[string]bool),
+		query:   make(map[string]bool),
+		fields:  make(map[string]bool),
+	}
+	r.add(defaultRedactHeaders, defaultRedactQuery, defaultRedactFields)
+	r.add(headers, query, fields)
+	return r
+}
+
+func (r *Redactor) add(headers, query, fields []string) {
+	for _, v := range headers {
+		r.headers[http.CanonicalHeaderKey(v)] = true
+	}
+	for _, v := range query {
+		r.query[strings.ToLower(v)] = true
+	}
+	for _, v := range fields {
+		r.fields[strings.ToLower(v)] = true
+	}
+}
+
+func (r *Redactor) clone() *Redactor {
+	val := &Redactor{
+		headers: make(map[string]bool),
+		query:   make(map[string]bool),
+		fields:  make(map[string]bool),
+	}
+	for k := range r.headers {
+		val.headers[k] = true
+	}
+	for k := range r.query {
+		val.query[k] = true
+	}
+	for k := range r.fields {
+		val.fields[k] = true
+	}
+	return val
+}
+
+// Header 返回隐藏敏感信息之后的 header 副本
+func (r *Redactor) Header(header http.Header) http.Header {
+	val := header.Clone()
+	for k, v := range val {
+		if r.headers[http.CanonicalHeaderKey(k)] {
+			for i := range v {
+				v[i] = redactMask
+			}
+		}
+	}
+	return val
+}
+
+// URL 返回隐藏敏感 query 参数之后的 URL 副本
+func (r *Redactor) URL(u *url.URL) *url.URL {
+	val := *u
+	if val.User != nil {
+		val.User = url.User(val.User.Username())
+	}
+	if val.RawQuery != "" {
+		val.RawQuery = r.values(val.RawQuery, r.query)
+	}
+	return &val
+}
+
+func (r *Redactor) values(raw string, rules map[string]bool) string {
+	// 解析失败(例如截断的 body)时丢弃无法解析的部分, 其余字段仍然需要隐藏
+	values, err := url.ParseQuery(raw)
+	changed := err != nil
+	for k, v := range values {
+		if rules[strings.ToLower(k)] {
+			for i := range v {
+				v[i] = redactMask
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return raw
+	}
+	return values.Encode()
+}
+
+// Body 隐藏 JSON 或者 form body 中的敏感字段, 其他内容原样返回.
+// 不完整的 JSON(例如被截断)按照字段名扫描隐藏.
+func (r *Redactor) Body(raw []byte, contentType string) []byte {
+	val, _ := r.body(raw, contentType)
+	return val
+}
+
+// body 返回隐藏之后的 body, Content-Type 为 JSON 但是内容无法解析时返回 false
+func (r *Redactor) body(raw []byte, contentType string) ([]byte, bool) {
+	if len(raw) == 0 {
+		return raw, true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		return []byte(r.values(string(raw), r.fields)), true
+	}
+
+	trim := bytes.TrimSpace(raw)
+	if len(trim) == 0 || (trim[0] != '{' && trim[0] != '[') {
+		isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+		return raw, !isJSON || json.Valid(trim)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(trim))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return r.scan(raw), true
+	}
+	if !r.walk(value) {
+		return raw, true
+	}
+	val, err := json.Marshal(value)
+	if err != nil {
+		return r.scan(raw), true
+	}
+	return val, true
+}
+
+// scan 在不完整的 JSON 中查找敏感字段名, 隐藏字段的值(到截断处为止)
+func (r *Redactor) scan(raw []byte) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(raw); {
+		if raw[i] != '"' {
+			buf.WriteByte(raw[i])
+			i++
+			continue
+		}
+
+		end := skipJSONValue(raw, i)
+		colon := end
+		for colon < len(raw) && isJSONSpace(raw[colon]) {
+			colon++
+		}
+		buf.Write(raw[i:end])
+		var key string
+		if colon >= len(raw) || raw[colon] != ':' || json.Unmarshal(raw[i:end], &key) != nil ||
+			!r.fields[strings.ToLower(key)] {
+			i = end
+			continue
+		}
+
+		value := colon + 1
+		for value < len(raw) && isJSONSpace(raw[value]) {
+			value++
+		}
+		buf.Write(raw[end:value])
+		buf.WriteString(`"` + redactMask + `"`)
+		i = skipJSONValue(raw, value)
+	}
+	return buf.Bytes()
+}
+
+// skipJSONValue 返回从 i 开始的 JSON 值的结束位置, 值不完整时返回 len(raw)
+func skipJSONValue(raw []byte, i int) int {
+	depth := 0
+	for i < len(raw) {
+		switch raw[i] {
+		case '"':
+			i++
+			for i < len(raw) && raw[i] != '"' {
+				if raw[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(raw) {
+				return len(raw)
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth < 0 {
+				return i
+			}
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		default:
+			if depth == 0 && isJSONSpace(raw[i]) {
+				return i
+			}
+		}
+		i++
+		if depth == 0 && (raw[i-1] == '"' || raw[i-1] == '}' || raw[i-1] == ']') {
+			return i
+		}
+	}
+	return len(raw)
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func (r *Redactor) walk(value interface{}) (changed bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			if r.fields[strings.ToLower(k)] {
+				value[k] = redactMask
+				changed = true
+				continue
+			}
+			if r.walk(v) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, v := range value {
+			if r.walk(v) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// dumpConfig 控制 WithDump 的输出: 敏感信息隐藏, body 大小限制以及文件的保留数量和时间
+type dumpConfig struct {
+	redactor  *Redactor
+	bodyLimit int64 // 小于 0 表示不限制
+	maxFiles  int   // 0 表示不限制
+	maxAge    time.Duration
+
+	mu     sync.Mutex
+	pruned time.Time
+}
+
+func defaultDumpConfig() *dumpConfig {
+	return &dumpConfig{
+		redactor:  NewRedactor(nil, nil, nil),
+		bodyLimit: defaultDumpBodyLimit,
+		maxFiles:  defaultDumpMaxFiles,
+		maxAge:    defaultDumpMaxAge,
+	}
+}
+
+func (d *dumpConfig) clone() *dumpConfig {
+	return &dumpConfig{
+		redactor:  d.redactor.clone(),
+		bodyLimit: d.bodyLimit,
+		maxFiles:  d.maxFiles,
+		maxAge:    d.maxAge,
+	}
+}
+
+// redactBody 隐藏敏感字段并限制大小, 无法隐藏的 JSON body 使用占位符
+func (d *dumpConfig) redactBody(raw []byte, contentType string, total int64) []byte {
+	body, ok := d.redactor.body(raw, contentType)
+	if !ok {
+		return []byte(fmt.Sprintf("(%v bytes, unparseable, redacted)", total))
+	}
+	return d.limitBody(body, total)
+}
+
+func (d *dumpConfig) limitBody(raw []byte, total int64) []byte {
+	if d.bodyLimit < 0 || total <= d.bodyLimit {
+		return raw
+	}
+	if int64(len(raw)) > d.bodyLimit {
+		raw = raw[:d.bodyLimit]
+	}
+	return append(raw[:len(raw):len(raw)], []byte(fmt.Sprintf("\n... (%v bytes truncated)", total-int64(len(raw))))...)
+}
+
+func dumpKey(req *http.Request, now time.Time, kind string) string {
+	uv := req.URL
+	prefix := uv.Path[strings.LastIndex(uv.Path, "/")+1:]
+	return fmt.Sprintf("%v_%v_%v_%v.txt", prefix, strings.ToUpper(req.Method), now.Format("150405999"), kind)
+}
+
+// requestBody 读取请求 body, 不影响请求的发送
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+
+	raw, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(raw))
+	return raw, err
+}
+
+func (c *EmbedClient) dumpRequest(req *http.Request, now time.Time) {
+	config := c.config.dump
+	raw, err := requestBody(req)
+	if err != nil {
+		return
+	}
+
+	clone := req.Clone(req.Context())
+	clone.URL = config.redactor.URL(req.URL)
+	clone.Header = config.redactor.Header(req.Header)
+	body := config.redactBody(raw, req.Header.Get("Content-Type"), int64(len(raw)))
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+	clone.TransferEncoding = nil
+
+	dump, _ := httputil.DumpRequestOut(clone, true)
+	c.writeDump(dumpKey(req, now, "REQ"), dump)
+}
+
+func (c *EmbedClient) dumpResponse(req *http.Request, resp *http.Response, now time.Time) {
+	config := c.config.dump
+
+	// 最多读取 bodyLimit+1 字节, 读取的内容放回 resp.Body
+	var raw []byte
+	var err error
+	if resp.Body != nil && resp.Body != http.NoBody {
+		reader := io.Reader(resp.Body)
+		if config.bodyLimit >= 0 {
+			reader = io.LimitReader(resp.Body, config.bodyLimit+1)
+		}
+		raw, err = io.ReadAll(reader)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+		if err != nil {
+			return
+		}
+	}
+
+	clone := *resp
+	clone.Header = config.redactor.Header(resp.Header)
+	total := int64(len(raw))
+	if config.bodyLimit >= 0 && total > config.bodyLimit {
+		total = resp.ContentLength
+		if total < int64(len(raw)) {
+			total = int64(len(raw))
+		}
+	}
+
+	var body []byte
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		body = []byte(fmt.Sprintf("(%v encoded body omitted)", encoding))
+	} else {
+		// raw 可能被截断, 截断的 JSON 按照字段名扫描隐藏之后再限制大小
+		body = config.redactBody(raw, resp.Header.Get("Content-Type"), total)
+	}
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+	clone.TransferEncoding = nil
+	clone.Header.Del("Content-Length")
+
+	dump, _ := httputil.DumpResponse(&clone, true)
+	c.writeDump(dumpKey(req, now, "RESP"), dump)
+}
+
+func (c *EmbedClient) writeDump(key string, raw []byte) {
+	_ = os.WriteFile(filepath.Join(c.config.dir, key), raw, 0600)
+	c.pruneDump()
+}
+
+// pruneDump 按照数量和时间删除旧的 dump 文件, 最多每分钟执行一次
+func (c *EmbedClient) pruneDump() {
+	config := c.config.dump
+	if config.maxFiles <= 0 && config.maxAge <= 0 {
+		return
+	}
+
+	config.mu.Lock()
+	defer config.mu.Unlock()
+	if time.Since(config.pruned) < dumpPruneInterval {
+		return
+	}
+	config.pruned = time.Now()
+
+	entries, err := os.ReadDir(c.config.dir)
+	if err != nil {
+		return
+	}
+
+	type dumpFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []dumpFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, "_REQ.txt") || strings.HasSuffix(name, "_RESP.txt")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, dumpFile{path: filepath.Join(c.config.dir, name), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	for i, file := range files {
+		if (config.maxFiles > 0 && i >= config.maxFiles) ||
+			(config.maxAge > 0 && time.Since(file.modTime) > config.maxAge) {
+			_ = os.Remove(file.path)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+var requestIDHeaders = []string{
+	"X-Request-Id",
+	"X-Ca-Request-Id",
+	"X-Oss-Request-Id",
+	"X-Amz-Request-Id",
+	"X-Goog-Request-Id",
+	"X-Trace-Id",
+}
+
+// 常见的服务端错误码字段: aliyundrive {"code":"AccessTokenInvalid"}, google {"error":{"status":"UNAUTHENTICATED"}}
+var providerCodeFields = []string{
+	"code",
+	"error_code",
+	"errcode",
+	"error.status",
+	"error.code",
+	"error",
+}
+
+type CodeError struct {
+	Method  string
+	URL     string
+	Code    int
+	Message string
+
+	Header       http.Header
+	RequestID    string
+	RetryAfter   time.Duration
+	Attempt      int
+	ProviderCode string
+}
+
+func newCodeError(method, u string, response *http.Response, raw []byte, attempt int) CodeError {
+	err := CodeError{
+		Method:  method,
+		URL:     u,
+		Code:    response.StatusCode,
+		Header:  response.Header,
+		Attempt: attempt,
+	}
+
+	if !strings.Contains(response.Header.Get("content-type"), "text/html") {
+		err.Message = string(raw)
+	}
+
+	for _, key := range requestIDHeaders {
+		if val := response.Header.Get(key); val != "" {
+			err.RequestID = val
+			break
+		}
+	}
+
+	err.RetryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+
+	if gjson.ValidBytes(raw) {
+		for _, field := range providerCodeFields {
+			if val := gjson.GetBytes(raw, field); val.Exists() && val.Type != gjson.JSON {
+				err.ProviderCode = val.String()
+				break
+			}
+		}
+	}
+
+	return err
+}
+
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func (err CodeError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("%v %q : status: %v", err.Method, err.URL,
+			http.StatusText(err.Code))
+	}
+	return fmt.Sprintf("%v %q : (status:%v, message:%v)", err.Method, err.URL,
+		http.StatusText(err.Code), err.Message)
+}
+
+func asCodeError(err error) (CodeError, bool) {
+	var val CodeError
+	if errors.As(err, &val) {
+		return val, true
+	}
+	var ptr *CodeError
+	if errors.As(err, &ptr) && ptr != nil {
+		return *ptr, true
+	}
+	return val, false
+}
+
+func IsNotFound(err error) bool {
+	val, ok := asCodeError(err)
+	return ok && (val.Code == http.StatusNotFound || val.Code == http.StatusGone)
+}
+
+func IsUnauthorized(err error) bool {
+	val, ok := asCodeError(err)
+	return ok && val.Code == http.StatusUnauthorized
+}
+
+func IsForbidden(err error) bool {
+	val, ok := asCodeError(err)
+	return ok && val.Code == http.StatusForbidden
+}
+
+func IsRateLimited(err error) bool {
+	val, ok := asCodeError(err)
+	return ok && val.Code == http.StatusTooManyRequests
+}
+
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if val, ok := asCodeError(err); ok {
+		return val.Code == http.StatusRequestTimeout || val.Code == http.StatusGatewayTimeout
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"crypto/tls"
 	"encoding/gob"
 	"encoding/json"
 	"math/rand"
@@ -12,6 +13,8 @@ import (
 	"sync"
 	"time"
 	"unsafe"
+
+	"github.com/tiechui1994/tool/log"
 )
 
 var agents = []string{
@@ -78,6 +81,8 @@ var (
 
 var globalClient *EmbedClient
 
+var logger = log.Named("util")
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 	config := new(clientConfig)
@@ -87,6 +92,7 @@ func init() {
 	config.dialerKeepAlive = defaultDialerKeepAlive
 	config.connTimeout = 15 * time.Second
 	config.connLongTimeout = 30 * time.Second
+	config.requestIDHeader = DefaultRequestIDHeader
 
 	home := os.Getenv("HOME")
 	if home == "" {
@@ -94,6 +100,9 @@ func init() {
 	}
 	config.dir = filepath.Join(home, ".config/tool")
 	_ = os.MkdirAll(config.dir, 0775)
+	config.dump = defaultDumpConfig()
+	config.resolve = newResolveTable()
+	config.resolve.add(splitResolveEnv(os.Getenv(ResolveEnv))...)
 
 	globalClient = &EmbedClient{config: config}
 }
@@ -139,6 +148,50 @@ func RegisterInitCookie(name, cookie, endpoint string) {
 	WithInitClientCookie(name, cookie, endpoint).apply(globalClient.config)
 }
 
+func RegisterDumpRedact(headers, query, fields []string) {
+	WithDumpRedact(headers, query, fields).apply(globalClient.config)
+}
+
+func RegisterDumpBodyLimit(limit int64) {
+	WithDumpBodyLimit(limit).apply(globalClient.config)
+}
+
+func RegisterDumpRetention(maxFiles int, maxAge time.Duration) {
+	WithDumpRetention(maxFiles, maxAge).apply(globalClient.config)
+}
+
+func RegisterLocalAddr(addrs ...string) {
+	WithClientLocalAddr(addrs...).apply(globalClient.config)
+}
+
+func RegisterInterface(name string) {
+	WithClientInterface(name).apply(globalClient.config)
+}
+
+func RegisterIPFamily(family IPFamily) {
+	WithClientIPFamily(family).apply(globalClient.config)
+}
+
+func RegisterResolve(entries ...string) {
+	WithClientResolve(entries...).apply(globalClient.config)
+}
+
+func RegisterRetry(retry uint) {
+	WithClientRetry(retry).apply(globalClient.config)
+}
+
+func RegisterRateLimit(rate float64, burst int) {
+	WithClientRateLimit(rate, burst).apply(globalClient.config)
+}
+
+func RegisterTLS(config *tls.Config) {
+	WithClientTLS(config).apply(globalClient.config)
+}
+
+func RegisterRequestIDHeader(name string) {
+	WithClientRequestIDHeader(name).apply(globalClient.config)
+}
+
 func Dir() string {
 	return globalClient.config.dir
 }
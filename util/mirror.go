@@ -0,0 +1,175 @@
+package util
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorMinBackoff = 5 * time.Second
+	mirrorMaxBackoff = 5 * time.Minute
+)
+
+type mirrorHost struct {
+	host      string
+	index     int
+	failures  int
+	latency   time.Duration // EWMA of time to first byte, 0 means unknown
+	downUntil time.Time
+}
+
+// Mirror 一组等价的 host (镜像). 请求失败时按照健康状态和延迟顺序切换 host.
+//
+// host 按照后缀匹配, 例如 "lanzoux.com" 可以匹配 "wwx.lanzoux.com", 切换时保留子域名前缀.
+type Mirror struct {
+	mu    sync.Mutex
+	hosts []*mirrorHost
+}
+
+func NewMirror(hosts ...string) *Mirror {
+	m := &Mirror{}
+	for i, host := range hosts {
+		m.hosts = append(m.hosts, &mirrorHost{host: strings.ToLower(host), index: i})
+	}
+	return m
+}
+
+// Hosts 返回当前的尝试顺序: 可用的 host 按延迟升序, 未测量的 host 按原始顺序, 不可用的 host 在最后.
+func (m *Mirror) Hosts() []string {
+	return m.order("")
+}
+
+// match 查找 host (可以包含端口) 对应的镜像以及子域名前缀
+func (m *Mirror) match(host string) (entry *mirrorHost, prefix string) {
+	host = strings.ToLower(host)
+	for _, e := range m.hosts {
+		if host == e.host {
+			return e, ""
+		}
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, e := range m.hosts {
+		if host == e.host {
+			return e, ""
+		}
+		if strings.HasSuffix(host, "."+e.host) {
+			return e, host[:len(host)-len(e.host)]
+		}
+	}
+	return nil, ""
+}
+
+// order 计算尝试顺序, current 是请求 URL 的 host, 未测量时优先尝试
+func (m *Mirror) order(current string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cur, _ := m.match(current)
+	now := time.Now()
+	list := make([]*mirrorHost, len(m.hosts))
+	copy(list, m.hosts)
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		aDown, bDown := now.Before(a.downUntil), now.Before(b.downUntil)
+		if aDown != bDown {
+			return bDown
+		}
+		if aDown {
+			return a.downUntil.Before(b.downUntil)
+		}
+		if (a.latency > 0) != (b.latency > 0) {
+			return a.latency > 0
+		}
+		if a.latency > 0 {
+			return a.latency < b.latency
+		}
+		if a == cur || b == cur {
+			return a == cur
+		}
+		return a.index < b.index
+	})
+
+	hosts := make([]string, 0, len(list))
+	for _, v := range list {
+		hosts = append(hosts, v.host)
+	}
+	return hosts
+}
+
+// contains 返回 host 是否匹配镜像中的 host
+func (m *Mirror) contains(host string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, _ := m.match(host)
+	return entry != nil
+}
+
+// rewrite 将 u 的 host 替换为镜像 target, 保留子域名前缀和端口. u 的 host 不属于镜像时不修改.
+func (m *Mirror) rewrite(u string, target string) string {
+	uRL, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	m.mu.Lock()
+	entry, prefix := m.match(uRL.Host)
+	m.mu.Unlock()
+	if entry == nil {
+		return u
+	}
+
+	host := prefix + target
+	if port := uRL.Port(); port != "" && !strings.Contains(target, ":") {
+		host += ":" + port
+	}
+	uRL.Host = host
+	return uRL.String()
+}
+
+func (m *Mirror) markSuccess(u string, latency time.Duration) {
+	uRL, err := url.Parse(u)
+	if err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, _ := m.match(uRL.Host)
+	if entry == nil {
+		return
+	}
+	entry.failures = 0
+	entry.downUntil = time.Time{}
+	if entry.latency == 0 {
+		entry.latency = latency
+	} else {
+		entry.latency = (entry.latency*7 + latency*3) / 10
+	}
+}
+
+func (m *Mirror) markFailure(u string) {
+	uRL, err := url.Parse(u)
+	if err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, _ := m.match(uRL.Host)
+	if entry == nil {
+		return
+	}
+	entry.failures++
+	backoff := mirrorMinBackoff << uint(entry.failures-1)
+	if backoff > mirrorMaxBackoff || backoff <= 0 {
+		backoff = mirrorMaxBackoff
+	}
+	entry.downUntil = time.Now().Add(backoff)
+}
@@ -0,0 +1,249 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const defaultPoolConcurrency = 5
+
+// PoolMode 任务失败之后的处理方式
+type PoolMode int
+
+const (
+	// FailFast 第一个错误取消 ctx, 未开始的任务不再执行, 返回第一个错误
+	FailFast PoolMode = iota
+	// BestEffort 执行所有任务, 返回所有错误
+	BestEffort
+)
+
+// Errors 多个任务的错误
+type Errors []error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	values := make([]string, 0, len(e))
+	for _, err := range e {
+		values = append(values, err.Error())
+	}
+	return fmt.Sprintf("%v errors: %v", len(e), strings.Join(values, "; "))
+}
+
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+type poolConfig struct {
+	concurrency int
+	mode        PoolMode
+}
+
+type PoolOption func(*poolConfig)
+
+// WithPoolConcurrency 同时执行的任务数量, 默认 5
+func WithPoolConcurrency(concurrency int) PoolOption {
+	return func(config *poolConfig) {
+		if concurrency > 0 {
+			config.concurrency = concurrency
+		}
+	}
+}
+
+func WithPoolMode(mode PoolMode) PoolOption {
+	return func(config *poolConfig) {
+		config.mode = mode
+	}
+}
+
+// Pool 固定并发数量的任务池. Go 不会阻塞, 任务中可以继续提交任务(例如遍历目录树).
+type Pool struct {
+	config poolConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	queue   []func(ctx context.Context) error
+	running int
+	skipped bool
+	errs    Errors
+	wg      sync.WaitGroup
+}
+
+func NewPool(ctx context.Context, opts ...PoolOption) *Pool {
+	config := poolConfig{concurrency: defaultPoolConcurrency, mode: FailFast}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	p := &Pool{config: config}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p
+}
+
+// Context 任务使用的 ctx, FailFast 模式下第一个错误之后被取消
+func (p *Pool) Context() context.Context {
+	return p.ctx
+}
+
+// Go 提交任务, 并发数量未满时立即执行, 否则排队
+func (p *Pool) Go(task func(ctx context.Context) error) {
+	p.wg.Add(1)
+	p.mu.Lock()
+	p.queue = append(p.queue, task)
+	if p.running >= p.config.concurrency {
+		p.mu.Unlock()
+		return
+	}
+	p.running++
+	p.mu.Unlock()
+
+	go p.worker()
+}
+
+func (p *Pool) worker() {
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		task := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		p.run(task)
+		p.wg.Done()
+	}
+}
+
+func (p *Pool) run(task func(ctx context.Context) error) {
+	// 取消之后的任务不再执行, 取消的错误只记录一次
+	if err := p.ctx.Err(); err != nil {
+		p.mu.Lock()
+		skipped := p.skipped
+		p.skipped = true
+		p.mu.Unlock()
+		if !skipped {
+			p.fail(err)
+		}
+		return
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("task panic: %v", v)
+			}
+		}()
+		return task(p.ctx)
+	}()
+	if err != nil {
+		p.fail(err)
+	}
+}
+
+func (p *Pool) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config.mode == FailFast {
+		// 只记录第一个错误, 之后的错误大多是 ctx 取消导致的
+		if len(p.errs) == 0 {
+			p.errs = append(p.errs, err)
+			p.cancel()
+		}
+		return
+	}
+	p.errs = append(p.errs, err)
+}
+
+// Wait 等待所有任务(包括任务中提交的任务)结束. FailFast 返回第一个错误, BestEffort 返回 Errors.
+// Wait 之后 Pool 不能继续使用.
+func (p *Pool) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case len(p.errs) == 0:
+		return nil
+	case p.config.mode == FailFast:
+		return p.errs[0]
+	default:
+		return append(Errors(nil), p.errs...)
+	}
+}
+
+// Result 任务结果, Index 为任务序号
+type Result struct {
+	Index int
+	Value interface{}
+	Err   error
+}
+
+// Map 并发执行 n 个任务, 按照序号返回结果. 任务失败时对应的结果为 nil.
+func Map(ctx context.Context, n int, fn func(ctx context.Context, i int) (interface{}, error), opts ...PoolOption) ([]interface{}, error) {
+	values := make([]interface{}, n)
+	pool := NewPool(ctx, opts...)
+	for i := 0; i < n; i++ {
+		i := i
+		pool.Go(func(ctx context.Context) error {
+			value, err := fn(ctx, i)
+			if err != nil {
+				return err
+			}
+			values[i] = value
+			return nil
+		})
+	}
+	err := pool.Wait()
+	return values, err
+}
+
+// Stream 并发执行 n 个任务, 按照完成的顺序发送结果, 所有任务结束之后关闭 channel.
+// FailFast 模式下失败之后未开始的任务不再发送结果.
+func Stream(ctx context.Context, n int, fn func(ctx context.Context, i int) (interface{}, error), opts ...PoolOption) <-chan Result {
+	results := make(chan Result)
+	pool := NewPool(ctx, opts...)
+	for i := 0; i < n; i++ {
+		i := i
+		pool.Go(func(ctx context.Context) error {
+			value, err := fn(ctx, i)
+			select {
+			case results <- Result{Index: i, Value: value, Err: err}:
+			case <-ctx.Done():
+				if err == nil {
+					err = ctx.Err()
+				}
+			}
+			return err
+		})
+	}
+
+	go func() {
+		pool.Wait()
+		close(results)
+	}()
+	return results
+}
@@ -0,0 +1,554 @@
+package util
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProfileFile Dir() 下的 client 配置文件, 格式为 {"profile": {"key": value}}
+	ProfileFile = "client.json"
+	// DefaultProfile 所有 profile 继承 default 的配置
+	DefaultProfile = "default"
+	// ProfileEnvPrefix 环境变量 TOOL_<KEY> 设置 default, TOOL_<PROFILE>__<KEY> 设置指定的 profile
+	ProfileEnvPrefix = "TOOL_"
+)
+
+// ProfileError 配置错误, 包括配置来源, profile 以及出错的 key
+type ProfileError struct {
+	Source  string
+	Profile string
+	Key     string
+	Err     error
+}
+
+func (e *ProfileError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%v: %v", e.Source, e.Err)
+	}
+	return fmt.Sprintf("%v: %v.%v: %v", e.Source, e.Profile, e.Key, e.Err)
+}
+
+func (e *ProfileError) Unwrap() error {
+	return e.Err
+}
+
+// Profile 命名的 client 配置
+type Profile struct {
+	Name string
+
+	DNS             []string
+	DNSTimeout      time.Duration
+	DialerTimeout   time.Duration
+	ConnTimeout     time.Duration
+	ConnLongTimeout time.Duration
+	Proxy           string // 代理地址, "direct" 表示不使用代理
+	CookieJar       string
+	Resolve         []string
+	LocalAddr       []string
+	Interface       string
+	IPFamily        IPFamily
+
+	Retry     uint
+	RateLimit float64
+	RateBurst int
+
+	TLSVerify     bool
+	TLSServerName string
+	TLSMinVersion uint16
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+
+	tls *tls.Config
+}
+
+var profileKeys = map[string]func(p *Profile, value string) error{
+	"dns": func(p *Profile, value string) error {
+		p.DNS = splitList(value)
+		for _, v := range p.DNS {
+			if !strings.HasSuffix(v, ":53") {
+				return fmt.Errorf("invalid dns %q, expect ip:53", v)
+			}
+		}
+		return nil
+	},
+	"dns_timeout":       durationKey(func(p *Profile) *time.Duration { return &p.DNSTimeout }),
+	"dialer_timeout":    durationKey(func(p *Profile) *time.Duration { return &p.DialerTimeout }),
+	"conn_timeout":      durationKey(func(p *Profile) *time.Duration { return &p.ConnTimeout }),
+	"conn_long_timeout": durationKey(func(p *Profile) *time.Duration { return &p.ConnLongTimeout }),
+	"proxy": func(p *Profile, value string) error {
+		if value != "" && value != "direct" {
+			if _, err := parseProxy(value); err != nil {
+				return err
+			}
+		}
+		p.Proxy = value
+		return nil
+	},
+	"cookie_jar": func(p *Profile, value string) error {
+		p.CookieJar = value
+		return nil
+	},
+	"resolve": func(p *Profile, value string) error {
+		p.Resolve = splitResolveEnv(value)
+		for _, v := range p.Resolve {
+			if _, _, err := parseResolve(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	"local_addr": func(p *Profile, value string) error {
+		p.LocalAddr = splitList(value)
+		if len(parseLocalAddrs(p.LocalAddr)) != len(p.LocalAddr) {
+			return fmt.Errorf("invalid ip in %q", value)
+		}
+		return nil
+	},
+	"interface": func(p *Profile, value string) error {
+		p.Interface = value
+		return nil
+	},
+	"ip_family": func(p *Profile, value string) error {
+		families := map[string]IPFamily{
+			"": IPAny, "any": IPAny, "ipv4": IPv4Prefer, "ipv6": IPv6Prefer, "ipv4_only": IPv4Only, "ipv6_only": IPv6Only,
+		}
+		family, ok := families[strings.ToLower(value)]
+		if !ok {
+			return fmt.Errorf("invalid ip family %q, expect any, ipv4, ipv6, ipv4_only or ipv6_only", value)
+		}
+		p.IPFamily = family
+		return nil
+	},
+	"retry": func(p *Profile, value string) error {
+		retry, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid retry %q", value)
+		}
+		p.Retry = uint(retry)
+		return nil
+	},
+	"rate_limit": func(p *Profile, value string) error {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("invalid rate limit %q", value)
+		}
+		p.RateLimit = rate
+		return nil
+	},
+	"rate_burst": func(p *Profile, value string) error {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst < 0 {
+			return fmt.Errorf("invalid rate burst %q", value)
+		}
+		p.RateBurst = burst
+		return nil
+	},
+	"tls_verify": func(p *Profile, value string) (err error) {
+		if p.TLSVerify, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid bool %q", value)
+		}
+		return nil
+	},
+	"tls_server_name": func(p *Profile, value string) error {
+		p.TLSServerName = value
+		return nil
+	},
+	"tls_min_version": func(p *Profile, value string) error {
+		versions := map[string]uint16{
+			"": 0, "1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13,
+		}
+		version, ok := versions[value]
+		if !ok {
+			return fmt.Errorf("invalid tls version %q, expect 1.0, 1.1, 1.2 or 1.3", value)
+		}
+		p.TLSMinVersion = version
+		return nil
+	},
+	"tls_ca_file": func(p *Profile, value string) error {
+		p.TLSCAFile = value
+		return nil
+	},
+	"tls_cert_file": func(p *Profile, value string) error {
+		p.TLSCertFile = value
+		return nil
+	},
+	"tls_key_file": func(p *Profile, value string) error {
+		p.TLSKeyFile = value
+		return nil
+	},
+}
+
+func durationKey(field func(p *Profile) *time.Duration) func(p *Profile, value string) error {
+	return func(p *Profile, value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(p) = duration
+		return nil
+	}
+}
+
+func splitList(value string) []string {
+	var val []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			val = append(val, v)
+		}
+	}
+	return val
+}
+
+func parseProxy(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	uRL, err := url.Parse(value)
+	if err != nil || uRL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q", value)
+	}
+	return uRL, nil
+}
+
+// profileValue 将 JSON 值转换为字符串, 数组使用 "," 连接
+func profileValue(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", nil
+	}
+	switch raw[0] {
+	case '"':
+		var val string
+		err := json.Unmarshal(raw, &val)
+		return val, err
+	case '[':
+		var values []json.RawMessage
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return "", err
+		}
+		list := make([]string, 0, len(values))
+		for _, v := range values {
+			val, err := profileValue(v)
+			if err != nil {
+				return "", err
+			}
+			list = append(list, val)
+		}
+		return strings.Join(list, ","), nil
+	case '{':
+		return "", errors.New("expect string, number, bool or array")
+	case 'n':
+		return "", nil
+	default:
+		return string(raw), nil
+	}
+}
+
+func (p *Profile) set(source, key, value string) error {
+	setter, ok := profileKeys[key]
+	if !ok {
+		return &ProfileError{Source: source, Profile: p.Name, Key: key, Err: errors.New("unknown key")}
+	}
+	if err := setter(p, value); err != nil {
+		return &ProfileError{Source: source, Profile: p.Name, Key: key, Err: err}
+	}
+	return nil
+}
+
+// validate 检查 key 之间的依赖并加载证书
+func (p *Profile) validate(source string) error {
+	fail := func(key string, err error) error {
+		return &ProfileError{Source: source, Profile: p.Name, Key: key, Err: err}
+	}
+
+	if p.ConnTimeout > 0 && p.ConnLongTimeout > 0 && p.ConnLongTimeout < p.ConnTimeout {
+		return fail("conn_long_timeout", fmt.Errorf("less than conn_timeout %v", p.ConnTimeout))
+	}
+	if p.RateBurst > 0 && p.RateLimit == 0 {
+		return fail("rate_burst", errors.New("rate_limit is required"))
+	}
+
+	p.tls = nil
+	if !p.TLSVerify && p.TLSServerName == "" && p.TLSMinVersion == 0 && p.TLSCAFile == "" && p.TLSCertFile == "" && p.TLSKeyFile == "" {
+		return nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: !p.TLSVerify,
+		ServerName:         p.TLSServerName,
+		MinVersion:         p.TLSMinVersion,
+	}
+	if p.TLSCAFile != "" {
+		raw, err := os.ReadFile(p.TLSCAFile)
+		if err != nil {
+			return fail("tls_ca_file", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(raw) {
+			return fail("tls_ca_file", fmt.Errorf("no certificate in %v", p.TLSCAFile))
+		}
+	}
+	if (p.TLSCertFile == "") != (p.TLSKeyFile == "") {
+		if p.TLSCertFile == "" {
+			return fail("tls_cert_file", errors.New("required by tls_key_file"))
+		}
+		return fail("tls_key_file", errors.New("required by tls_cert_file"))
+	}
+	if p.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLSCertFile, p.TLSKeyFile)
+		if err != nil {
+			return fail("tls_cert_file", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	p.tls = config
+	return nil
+}
+
+// Options 将 profile 转换为 ClientOption, 未设置的 key 使用默认值
+func (p *Profile) Options() []ClientOption {
+	var opts []ClientOption
+	if len(p.DNS) > 0 {
+		opts = append(opts, WithClientDNS(p.DNS))
+	}
+	if p.DNSTimeout > 0 {
+		opts = append(opts, WithDNSTimeout(p.DNSTimeout))
+	}
+	if p.DialerTimeout > 0 {
+		opts = append(opts, WithDialerTimeout(p.DialerTimeout))
+	}
+	if p.ConnTimeout > 0 || p.ConnLongTimeout > 0 {
+		timeout, longTimeout := p.ConnTimeout, p.ConnLongTimeout
+		if timeout == 0 {
+			timeout = globalClient.config.connTimeout
+		}
+		opts = append(opts, WithConnTimeout(timeout, longTimeout))
+	}
+	switch p.Proxy {
+	case "":
+	case "direct":
+		opts = append(opts, WithClientProxy(func(*http.Request) (*url.URL, error) {
+			return nil, nil
+		}))
+	default:
+		proxy, _ := parseProxy(p.Proxy)
+		opts = append(opts, WithClientProxy(http.ProxyURL(proxy)))
+	}
+	if p.CookieJar != "" {
+		opts = append(opts, WithClientCookieJar(p.CookieJar))
+	}
+	if len(p.Resolve) > 0 {
+		opts = append(opts, WithClientResolve(p.Resolve...))
+	}
+	if len(p.LocalAddr) > 0 {
+		opts = append(opts, WithClientLocalAddr(p.LocalAddr...))
+	}
+	if p.Interface != "" {
+		opts = append(opts, WithClientInterface(p.Interface))
+	}
+	if p.IPFamily != IPAny {
+		opts = append(opts, WithClientIPFamily(p.IPFamily))
+	}
+	if p.Retry > 0 {
+		opts = append(opts, WithClientRetry(p.Retry))
+	}
+	if p.RateLimit > 0 {
+		opts = append(opts, WithClientRateLimit(p.RateLimit, p.RateBurst))
+	}
+	if p.tls != nil {
+		opts = append(opts, WithClientTLS(p.tls))
+	}
+	return opts
+}
+
+// profileEnvKeys 环境变量中大写的 key 对应的配置 key, 例如 "CONN_TIMEOUT" -> "conn_timeout"
+var profileEnvKeys = func() map[string]string {
+	keys := make(map[string]string, len(profileKeys))
+	for key := range profileKeys {
+		keys[strings.ToUpper(key)] = key
+	}
+	return keys
+}()
+
+// parseProfiles 解析配置文件和环境变量, 环境变量覆盖配置文件. 所有 profile 继承 default.
+func parseProfiles(source string, raw []byte, environ []string) (map[string]*Profile, error) {
+	files := make(map[string]map[string]json.RawMessage)
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &files); err != nil {
+			return nil, &ProfileError{Source: source, Err: err}
+		}
+	}
+
+	// 环境变量, name -> key -> (env, value)
+	type envValue struct{ env, value string }
+	envs := make(map[string]map[string]envValue)
+	for _, kv := range environ {
+		idx := strings.IndexByte(kv, '=')
+		if idx < 0 || !strings.HasPrefix(kv[:idx], ProfileEnvPrefix) {
+			continue
+		}
+		env, value := kv[:idx], kv[idx+1:]
+		// 只使用大写的环境变量名, 例如 TOOL_CONN_TIMEOUT, TOOL_ALIYUNDRIVE__RETRY
+		if env != strings.ToUpper(env) {
+			continue
+		}
+		// TOOL_RESOLVE 由全局的 resolve 表读取(见 ResolveEnv), 不作为 default 的配置
+		if env == ResolveEnv {
+			continue
+		}
+		name, upper := DefaultProfile, strings.TrimPrefix(env, ProfileEnvPrefix)
+		if idx := strings.Index(upper, "__"); idx > 0 {
+			name, upper = strings.ToLower(upper[:idx]), upper[idx+2:]
+			if _, ok := profileEnvKeys[upper]; !ok {
+				// 可能是其他程序使用的环境变量, 只提示不报错
+				logger.Warnln("profile: env %v: unknown key %v", env, strings.ToLower(upper))
+				continue
+			}
+		} else if _, ok := profileEnvKeys[upper]; !ok {
+			// 其他 TOOL_* 环境变量(例如 TOOL_LOG)
+			continue
+		}
+		key := profileEnvKeys[upper]
+		if envs[name] == nil {
+			envs[name] = make(map[string]envValue)
+		}
+		envs[name][key] = envValue{env: env, value: value}
+	}
+
+	build := func(base *Profile, name string) (*Profile, error) {
+		profile := &Profile{Name: name}
+		if base != nil {
+			*profile = *base
+			profile.Name = name
+		}
+
+		keys := make([]string, 0, len(files[name]))
+		for key := range files[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, err := profileValue(files[name][key])
+			if err != nil {
+				return nil, &ProfileError{Source: source, Profile: name, Key: key, Err: err}
+			}
+			if err = profile.set(source, key, value); err != nil {
+				return nil, err
+			}
+		}
+		for key, env := range envs[name] {
+			if err := profile.set("env "+env.env, key, env.value); err != nil {
+				return nil, err
+			}
+		}
+		if err := profile.validate(source); err != nil {
+			return nil, err
+		}
+		return profile, nil
+	}
+
+	base, err := build(nil, DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+	profiles := map[string]*Profile{DefaultProfile: base}
+	names := make(map[string]bool)
+	for name := range files {
+		names[name] = true
+	}
+	for name := range envs {
+		names[name] = true
+	}
+	for name := range names {
+		if name == DefaultProfile {
+			continue
+		}
+		if profiles[name], err = build(base, name); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+var profiles struct {
+	sync.Mutex
+	loaded bool
+	values map[string]*Profile
+}
+
+// LoadProfiles 重新读取 Dir() 下的 ProfileFile 以及 TOOL_* 环境变量. 配置文件不存在时只使用环境变量.
+func LoadProfiles() (map[string]*Profile, error) {
+	source := filepath.Join(Dir(), ProfileFile)
+	raw, err := os.ReadFile(source)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, &ProfileError{Source: source, Err: err}
+	}
+
+	values, err := parseProfiles(source, raw, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	profiles.Lock()
+	profiles.loaded = true
+	profiles.values = values
+	profiles.Unlock()
+	return values, nil
+}
+
+// GetProfile 返回指定名称的 profile, 第一次调用时加载配置
+func GetProfile(name string) (*Profile, error) {
+	profiles.Lock()
+	loaded, values := profiles.loaded, profiles.values
+	profiles.Unlock()
+
+	if !loaded {
+		var err error
+		if values, err = LoadProfiles(); err != nil {
+			return nil, err
+		}
+	}
+	if name == "" {
+		name = DefaultProfile
+	}
+	profile, ok := values[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found", name)
+	}
+	return profile, nil
+}
+
+// NewProfileClient 使用 profile 创建 EmbedClient, opts 覆盖 profile 的配置
+func NewProfileClient(name string, opts ...ClientOption) (*EmbedClient, error) {
+	profile, err := GetProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(append(profile.Options(), opts...)...), nil
+}
+
+// RegisterProfile 将 profile 应用到全局 client
+func RegisterProfile(name string) error {
+	profile, err := GetProfile(name)
+	if err != nil {
+		return err
+	}
+	for _, opt := range profile.Options() {
+		opt.apply(globalClient.config)
+	}
+	return nil
+}
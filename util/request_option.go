@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/tiechui1994/tool/log"
 )
 
 type httpOptions struct {
@@ -18,12 +21,25 @@ type httpOptions struct {
 	header        map[string]string
 	body          io.Reader
 	retry         int
+	timeout       time.Duration
+	attempt       time.Duration
 	ctx           context.Context
 	beforeRequest func(r *http.Request)
 	afterResponse func(w *http.Response)
 	randomHost    func(string) string
+	mirror        *Mirror
+	mirrorSelect  func(u string)
+	tokenSource   *TokenSource
+	charset       bool
+	charsetBack   string
+	charsetFound  func(charset string)
 	proxy         func(*http.Request) (*url.URL, error)
 	proxyDail     func(ctx context.Context, network, addr string) (net.Conn, error)
+	bind          *bindConfig
+	coalesce      bool
+	coalesceKeys  []string
+	ws            wsConfig
+	logger        *log.Logger // 带有请求 ID 的 logger
 }
 
 func (opt *httpOptions) Clone() *httpOptions {
@@ -107,6 +123,58 @@ func WithRetry(retry uint) Option {
 	})
 }
 
+// WithTimeout 限制整个调用的时间, 包括所有重试, 重试之间的等待以及读取响应 body.
+// 流式请求(File, EventStream, JSONStream)限制的是整个流的时间.
+func WithTimeout(timeout time.Duration) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.timeout = timeout
+	})
+}
+
+// WithAttemptTimeout 限制单次请求的时间, 超时的请求按照 WithRetry 重试, 且不会超过 WithTimeout.
+// 流式请求(File, EventStream, JSONStream)只限制获取响应头的时间.
+func WithAttemptTimeout(timeout time.Duration) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.attempt = timeout
+	})
+}
+
+// WithLocalAddr 请求使用的本地地址, 覆盖 WithClientLocalAddr 和 WithClientInterface
+func WithLocalAddr(addrs ...string) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.bind = o.bind.merge(&bindConfig{localAddrs: parseLocalAddrs(addrs)})
+	})
+}
+
+// WithInterface 请求使用网卡 name 上的地址, 覆盖 WithClientLocalAddr 和 WithClientInterface
+func WithInterface(name string) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.bind = o.bind.merge(&bindConfig{iface: name})
+	})
+}
+
+// WithIPFamily 请求优先使用或者只使用 IPv4/IPv6
+func WithIPFamily(family IPFamily) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.bind = o.bind.merge(&bindConfig{family: family})
+	})
+}
+
+// WithCoalesce 合并相同的并发 GET/HEAD 请求, 共享响应内容和 header.
+// 相同请求指 method, url, Authorization(包括 WithTokenSource 的 token), Cookie 以及 headers 指定的 header 都相同.
+// 设置了 WithCharset, WithBeforeRequest, WithAfterResponse 或者 WithMirror 时不合并.
+func WithCoalesce(headers ...string) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.coalesce = true
+		o.coalesceKeys = headers
+	})
+}
+
+// coalescible 返回请求是否可以合并, 改变响应内容或者需要回调的选项不能共享其他调用者的结果
+func (opt *httpOptions) coalescible() bool {
+	return !opt.charset && opt.beforeRequest == nil && opt.afterResponse == nil && opt.mirror == nil
+}
+
 func WithContext(ctx context.Context) Option {
 	return newFuncDialOption(func(o *httpOptions) {
 		o.ctx = ctx
@@ -140,12 +208,45 @@ func WithAfterResponse(f func(w *http.Response)) Option {
 	})
 }
 
+// Deprecated: this method is currently unused. It is use new replace
+//
+// WithMirror()
 func WithRandomHost(f func(string) string) Option {
 	return newFuncDialOption(func(opt *httpOptions) {
 		opt.randomHost = f
 	})
 }
 
+// WithMirror 请求失败(可重试)时按顺序切换镜像 host, 至少尝试每个镜像一次.
+// selected 返回最终响应请求的 URL, 后续请求可以使用同一个镜像.
+func WithMirror(mirror *Mirror, selected func(u string)) Option {
+	return newFuncDialOption(func(opt *httpOptions) {
+		opt.mirror = mirror
+		opt.mirrorSelect = selected
+	})
+}
+
+// WithTokenSource 请求携带 Authorization, 响应 401 时刷新 token 并重放一次请求
+func WithTokenSource(source *TokenSource) Option {
+	return newFuncDialOption(func(opt *httpOptions) {
+		opt.tokenSource = source
+	})
+}
+
+// WithCharset 将文本响应转码为 UTF-8, 字符集从 BOM, Content-Type 和 <meta charset> 检测,
+// 无法检测时使用 fallback(例如 gb18030). detected 返回检测到的字符集.
+func WithCharset(detected func(charset string), fallback ...string) Option {
+	back := ""
+	if len(fallback) > 0 {
+		back = fallback[0]
+	}
+	return newFuncDialOption(func(opt *httpOptions) {
+		opt.charset = true
+		opt.charsetBack = back
+		opt.charsetFound = detected
+	})
+}
+
 func WithProxy(f func(*http.Request) (*url.URL, error)) Option {
 	return newFuncDialOption(func(opt *httpOptions) {
 		opt.proxy = f
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/tiechui1994/tool/log"
+)
+
+// DefaultRequestIDHeader 发送请求 ID 默认使用的 header
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// requestIDKey 请求 ID 在日志中的字段名
+const requestIDKey = "request_id"
+
+type requestIDContextKey struct{}
+
+// RequestID 返回 ctx 中的请求 ID, 在 WithBeforeRequest 等回调中可以通过 request.Context() 获取
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// NewID 返回 16 位十六进制的随机 ID, 用于请求 ID 和任务 ID
+func NewID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// withRequestID 为请求生成 ID(header 中已经设置时使用设置的值), 保存到 ctx 和 ctx 的 Logger 中, 并设置到请求的 header.
+// 重试使用相同的 ID. ctx 中的 Logger 字段(例如任务 ID) 也会出现在 util 的日志中.
+func (c *EmbedClient) withRequestID(options *httpOptions) {
+	name := c.config.requestIDHeader
+	var id string
+	for k, v := range options.header {
+		if name != "" && strings.EqualFold(k, name) {
+			id = v
+		}
+	}
+	if id == "" {
+		id = NewID()
+		if name != "" {
+			// WithHeader 使用调用者的 map, 不能修改
+			options.header = cloneHeader(options.header)
+			options.header[name] = id
+		}
+	}
+
+	ctxLogger := log.FromContext(options.ctx).With(requestIDKey, id)
+	options.ctx = log.WithContext(context.WithValue(options.ctx, requestIDContextKey{}, id), ctxLogger)
+	options.logger = logger.With(ctxLogger.Fields())
+}
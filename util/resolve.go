@@ -0,0 +1,127 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ResolveEnv 环境变量, 多个条目使用 ";" 或者空白分隔, 例如:
+//
+//	TOOL_RESOLVE="api.aliyundrive.com:443:1.2.3.4;*.lanzoug.com:*:5.6.7.8,[::1]"
+//
+// 指定 profile 的映射使用 TOOL_<PROFILE>__RESOLVE.
+const ResolveEnv = "TOOL_RESOLVE"
+
+// resolveTable host 到地址的静态映射, 在 DNS 之前查询(等同于 curl --resolve).
+// key 为 "host:port", port 为 "*" 表示任意端口, host 为 "*.domain" 匹配子域名, 为 "*" 匹配任意 host.
+type resolveTable struct {
+	mu      sync.RWMutex
+	entries map[string][]net.IP
+}
+
+func newResolveTable() *resolveTable {
+	return &resolveTable{entries: make(map[string][]net.IP)}
+}
+
+func (t *resolveTable) clone() *resolveTable {
+	val := newResolveTable()
+	if t == nil {
+		return val
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for k, v := range t.entries {
+		val.entries[k] = v
+	}
+	return val
+}
+
+// parseResolve 解析 "HOST:PORT:ADDR[,ADDR]...", IPv6 地址可以使用 [] 包裹
+func parseResolve(entry string) (key string, ips []net.IP, err error) {
+	parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", nil, fmt.Errorf("invalid resolve entry %q, expect HOST:PORT:ADDR", entry)
+	}
+
+	host, port := strings.ToLower(strings.TrimSuffix(parts[0], ".")), parts[1]
+	if host != "*" && strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return "", nil, fmt.Errorf("invalid resolve host %q", parts[0])
+	}
+	if port != "*" {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return "", nil, fmt.Errorf("invalid resolve port %q", port)
+		}
+	}
+
+	for _, addr := range strings.Split(parts[2], ",") {
+		addr = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(addr), "["), "]")
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return "", nil, fmt.Errorf("invalid resolve address %q", addr)
+		}
+		ips = append(ips, ip)
+	}
+
+	return host + ":" + port, ips, nil
+}
+
+// add 添加映射, 无效的条目被忽略
+func (t *resolveTable) add(entries ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		key, ips, err := parseResolve(entry)
+		if err != nil {
+			logger.Warnln("resolve: %v", err)
+			continue
+		}
+		t.entries[key] = ips
+	}
+}
+
+// lookup 按照 host:port, host:*, *.domain:port, *.domain:*, *:port, *:* 的顺序查询
+func (t *resolveTable) lookup(host, port string) ([]net.IP, bool) {
+	if t == nil {
+		return nil, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.entries) == 0 {
+		return nil, false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	find := func(name string) ([]net.IP, bool) {
+		if ips, ok := t.entries[name+":"+port]; ok {
+			return ips, true
+		}
+		ips, ok := t.entries[name+":*"]
+		return ips, ok
+	}
+
+	if ips, ok := find(host); ok {
+		return ips, true
+	}
+	for domain := host; ; {
+		idx := strings.IndexByte(domain, '.')
+		if idx < 0 {
+			break
+		}
+		domain = domain[idx+1:]
+		if ips, ok := find("*." + domain); ok {
+			return ips, true
+		}
+	}
+	return find("*")
+}
+
+func splitResolveEnv(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ';' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
@@ -0,0 +1,368 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStreamRetry = 3 * time.Second
+	maxStreamLine      = 16 * 1024 * 1024
+)
+
+// ErrStreamDone handler 返回 ErrStreamDone 时正常结束读取流
+var ErrStreamDone = errors.New("stream done")
+
+// Event 是 text/event-stream 的一个事件
+type Event struct {
+	ID    string
+	Event string
+	Data  []byte
+	Retry time.Duration
+}
+
+func EventStream(u string, handler func(event Event) error, opts ...Option) error {
+	return globalClient.EventStream(u, handler, opts...)
+}
+
+func EventStreamChan(u string, opts ...Option) (<-chan Event, <-chan error) {
+	return globalClient.EventStreamChan(u, opts...)
+}
+
+func JSONStream(method, u string, handler func(raw json.RawMessage) error, opts ...Option) error {
+	return globalClient.JSONStream(method, u, handler, opts...)
+}
+
+func JSONStreamChan(method, u string, opts ...Option) (<-chan json.RawMessage, <-chan error) {
+	return globalClient.JSONStreamChan(method, u, opts...)
+}
+
+// stream 发送请求并返回未读取的响应, 非 2xx 状态返回 CodeError. attempt timeout 只限制获取响应头的时间.
+func (c *EmbedClient) stream(method, u string, options *httpOptions) (*http.Response, error) {
+	ctx, stop, cancel := options.attemptContext()
+	request, err := http.NewRequestWithContext(ctx, method, u, options.body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	for k, v := range options.header {
+		request.Header.Set(k, v)
+	}
+	if request.Header.Get("User-Agent") == "" {
+		request.Header.Set("User-Agent", hashUserAgent(u))
+	}
+	if options.tokenSource != nil {
+		token, err := options.tokenSource.Token()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		request.Header.Set("Authorization", token.Type()+" "+token.AccessToken)
+	}
+	if options.beforeRequest != nil {
+		options.beforeRequest(request)
+	}
+
+	if err = c.config.limiter.wait(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	response, err := c.httpClient(options).Do(request)
+	if err = options.attemptError(err, stop()); err != nil {
+		cancel()
+		return nil, err
+	}
+	if options.afterResponse != nil {
+		options.afterResponse(response)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+		response.Body.Close()
+		cancel()
+		return nil, newCodeError(method, u, response, raw, 1)
+	}
+
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// scanLines 按照 \r\n, \n, \r 分割行
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func newLineScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLine)
+	scanner.Split(scanLines)
+	return scanner
+}
+
+// readEvents 解析 event-stream, lastID 和 retry 在重连时使用
+func readEvents(reader io.Reader, lastID *string, retry *time.Duration, handler func(event Event) error) error {
+	var data bytes.Buffer
+	var name string
+	id := *lastID
+
+	scanner := newLineScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			*lastID = id
+			if data.Len() == 0 {
+				name = ""
+				continue
+			}
+
+			event := Event{
+				ID:    id,
+				Event: name,
+				Data:  bytes.TrimSuffix(data.Bytes(), []byte("\n")),
+				Retry: *retry,
+			}
+			if event.Event == "" {
+				event.Event = "message"
+			}
+			event.Data = append([]byte(nil), event.Data...)
+			data.Reset()
+			name = ""
+			if err := handler(event); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				id = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// EventStream 读取 text/event-stream, 每个事件调用 handler.
+// 连接断开后等待 retry(服务端 retry 字段, 默认 3s) 携带 Last-Event-ID 重连, 直到 ctx 取消或者 handler 返回错误.
+func (c *EmbedClient) EventStream(u string, handler func(event Event) error, opts ...Option) error {
+	c.init()
+
+	options := c.options(opts)
+	defer options.withTimeout()()
+	return c.eventStream(u, handler, options)
+}
+
+func (c *EmbedClient) eventStream(u string, handler func(event Event) error, options *httpOptions) error {
+	options.header = cloneHeader(options.header)
+	setHeader(options.header, "Accept", "text/event-stream")
+	setHeader(options.header, "Cache-Control", "no-cache")
+
+	var lastID string
+	retry := defaultStreamRetry
+	for {
+		if lastID != "" {
+			setHeader(options.header, "Last-Event-ID", lastID)
+		}
+
+		response, err := c.stream(http.MethodGet, u, options)
+		if err == nil {
+			if response.StatusCode == http.StatusNoContent {
+				response.Body.Close()
+				return nil
+			}
+			err = readEvents(response.Body, &lastID, &retry, handler)
+			response.Body.Close()
+		}
+
+		if err == ErrStreamDone {
+			return nil
+		}
+		if options.ctx.Err() != nil {
+			return options.ctx.Err()
+		}
+		// 服务端关闭连接(err == nil) 或者网络错误时重连
+		if err != nil && !IsRetryable(err) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-options.ctx.Done():
+			timer.Stop()
+			return options.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *EmbedClient) EventStreamChan(u string, opts ...Option) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+
+	c.init()
+
+	options := c.options(opts)
+	cancel := options.withTimeout()
+	ctx := options.ctx
+
+	go func() {
+		defer cancel()
+		defer close(events)
+		errs <- c.eventStream(u, func(event Event) error {
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, options)
+	}()
+
+	return events, errs
+}
+
+// JSONStream 读取 newline-delimited JSON 流, 每一行调用 handler. 空行被忽略.
+func (c *EmbedClient) JSONStream(method, u string, handler func(raw json.RawMessage) error, opts ...Option) error {
+	c.init()
+
+	options := c.options(opts)
+	defer options.withTimeout()()
+	return c.jsonStream(method, u, handler, options)
+}
+
+func (c *EmbedClient) jsonStream(method, u string, handler func(raw json.RawMessage) error, options *httpOptions) error {
+	options.header = cloneHeader(options.header)
+	if !hasHeader(options.header, "Accept") {
+		setHeader(options.header, "Accept", "application/x-ndjson")
+	}
+
+	response, err := c.stream(method, u, options)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	scanner := newLineScanner(response.Body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return errors.New("invalid json line: " + string(line))
+		}
+
+		err = handler(append(json.RawMessage(nil), line...))
+		if err == ErrStreamDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = scanner.Err(); err != nil && options.ctx.Err() != nil {
+		return options.ctx.Err()
+	}
+	return err
+}
+
+func (c *EmbedClient) JSONStreamChan(method, u string, opts ...Option) (<-chan json.RawMessage, <-chan error) {
+	values := make(chan json.RawMessage)
+	errs := make(chan error, 1)
+
+	c.init()
+
+	options := c.options(opts)
+	cancel := options.withTimeout()
+	ctx := options.ctx
+
+	go func() {
+		defer cancel()
+		defer close(values)
+		errs <- c.jsonStream(method, u, func(raw json.RawMessage) error {
+			select {
+			case values <- raw:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, options)
+	}()
+
+	return values, errs
+}
+
+func cloneHeader(header map[string]string) map[string]string {
+	val := make(map[string]string, len(header)+2)
+	for k, v := range header {
+		val[k] = v
+	}
+	return val
+}
+
+func hasHeader(header map[string]string, key string) bool {
+	for k := range header {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeader 设置 header, 删除大小写不同的同名 key
+func setHeader(header map[string]string, key, value string) {
+	for k := range header {
+		if strings.EqualFold(k, key) {
+			delete(header, k)
+		}
+	}
+	header[key] = value
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// timeoutError 单次请求超时, 可以重试
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("attempt timeout after %v", e.timeout)
+}
+
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func (e timeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// withTimeout 按照 WithTimeout 设置 options.ctx 的截止时间
+func (opt *httpOptions) withTimeout() context.CancelFunc {
+	if opt.timeout <= 0 {
+		return func() {}
+	}
+	var cancel context.CancelFunc
+	opt.ctx, cancel = context.WithTimeout(opt.ctx, opt.timeout)
+	return cancel
+}
+
+// attemptContext 返回单次请求的 ctx, stop 停止计时并返回是否已经超时.
+// 计时结束之前的取消都来自于 attempt timeout, 之后 ctx 只随 cancel 或者 options.ctx 结束.
+func (opt *httpOptions) attemptContext() (ctx context.Context, stop func() bool, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(opt.ctx)
+	if opt.attempt <= 0 {
+		return ctx, func() bool { return false }, cancel
+	}
+	timer := time.AfterFunc(opt.attempt, cancel)
+	return ctx, func() bool { return !timer.Stop() && opt.ctx.Err() == nil }, cancel
+}
+
+// attemptError 将 attempt timeout 导致的取消转换为 timeoutError
+func (opt *httpOptions) attemptError(err error, expired bool) error {
+	if err != nil && expired {
+		return fmt.Errorf("%v: %w", err, timeoutError{timeout: opt.attempt})
+	}
+	return err
+}
+
+// wait 重试之前等待 try 秒, ctx 结束时返回 false
+func wait(ctx context.Context, try int) bool {
+	timer := time.NewTimer(time.Second * time.Duration(try))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// cancelBody 关闭 body 时释放请求的 ctx
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tiechui1994/tool/aliyun/singleflight"
+)
+
+const defaultTokenSkew = time.Minute
+
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Type 返回 Authorization 的类型, 默认为 Bearer
+func (t Token) Type() string {
+	if t.TokenType == "" {
+		return "Bearer"
+	}
+	return t.TokenType
+}
+
+// valid 判断 token 在 skew 之后是否仍然有效. Expiry 为空时认为一直有效, 直到服务端返回 401
+func (t Token) valid(skew time.Duration) bool {
+	if t.AccessToken == "" {
+		return false
+	}
+	if t.Expiry.IsZero() {
+		return true
+	}
+	return time.Now().Add(skew).Before(t.Expiry)
+}
+
+// TokenSource 缓存 token, 在过期前(skew)或者服务端返回 401 时自动刷新.
+// 并发刷新会合并成一次调用.
+type TokenSource struct {
+	mu      sync.RWMutex
+	token   Token
+	skew    time.Duration
+	refresh func(token Token) (Token, error)
+	persist func(token Token)
+	group   singleflight.Group
+}
+
+type TokenSourceOption func(source *TokenSource)
+
+// WithTokenSkew token 在过期前 skew 时间内刷新
+func WithTokenSkew(skew time.Duration) TokenSourceOption {
+	return func(source *TokenSource) {
+		if skew >= 0 {
+			source.skew = skew
+		}
+	}
+}
+
+// WithTokenPersist 刷新成功之后调用 persist 保存 token
+func WithTokenPersist(persist func(token Token)) TokenSourceOption {
+	return func(source *TokenSource) {
+		source.persist = persist
+	}
+}
+
+func NewTokenSource(token Token, refresh func(token Token) (Token, error), opts ...TokenSourceOption) *TokenSource {
+	source := &TokenSource{
+		token:   token,
+		skew:    defaultTokenSkew,
+		refresh: refresh,
+	}
+	for _, opt := range opts {
+		opt(source)
+	}
+	return source
+}
+
+// Token 返回有效的 token, 即将过期时刷新
+func (s *TokenSource) Token() (Token, error) {
+	s.mu.RLock()
+	token := s.token
+	s.mu.RUnlock()
+	if token.valid(s.skew) {
+		return token, nil
+	}
+
+	return s.refreshFrom(token.AccessToken)
+}
+
+// Refresh 强制刷新 token
+func (s *TokenSource) Refresh() (Token, error) {
+	s.mu.RLock()
+	access := s.token.AccessToken
+	s.mu.RUnlock()
+	return s.refreshFrom(access)
+}
+
+// Set 替换当前 token, 例如重新授权之后
+func (s *TokenSource) Set(token Token) {
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+
+	if s.persist != nil {
+		s.persist(token)
+	}
+}
+
+// refreshFrom 刷新 access token. 如果 access 已经被其他调用刷新, 直接返回当前 token.
+func (s *TokenSource) refreshFrom(access string) (Token, error) {
+	if s.refresh == nil {
+		return Token{}, errors.New("token source without refresh func")
+	}
+
+	val, err, _ := s.group.Do("refresh", func() (interface{}, error) {
+		s.mu.RLock()
+		current := s.token
+		s.mu.RUnlock()
+		if current.AccessToken != access && current.valid(s.skew) {
+			return current, nil
+		}
+
+		token, err := s.refresh(current)
+		if err != nil {
+			return current, err
+		}
+		if token.RefreshToken == "" {
+			token.RefreshToken = current.RefreshToken
+		}
+		s.Set(token)
+		return token, nil
+	})
+	if err != nil {
+		return Token{}, err
+	}
+
+	return val.(Token), nil
+}
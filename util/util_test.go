@@ -1,10 +1,26 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/tiechui1994/tool/log"
 )
 
 func init() {
@@ -90,3 +106,807 @@ func TestClearCookie(t *testing.T) {
 	_, _ = GET("http://127.0.0.1:8080/")
 	t.Log("GetCookies 3:", GetCookies(u))
 }
+
+func TestMirror(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downHost := down.Listener.Addr().String()
+	down.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer up.Close()
+	upHost := up.Listener.Addr().String()
+
+	mirror := NewMirror(downHost, upHost)
+	var selected string
+	raw, err := NewClient().GET("http://"+downHost+"/share", WithMirror(mirror, func(u string) {
+		selected = u
+	}))
+	if err != nil || string(raw) != "ok" {
+		t.Fatalf("GET failed: %v %s", err, raw)
+	}
+	if selected != "http://"+upHost+"/share" {
+		t.Fatalf("selected: %v", selected)
+	}
+	if hosts := mirror.Hosts(); hosts[0] != upHost {
+		t.Fatalf("order: %v", hosts)
+	}
+
+	// host 不属于镜像时请求原始 host
+	selected = ""
+	raw, err = NewClient().GET(up.URL+"/share", WithMirror(NewMirror(downHost), func(u string) {
+		selected = u
+	}))
+	if err != nil || string(raw) != "ok" || selected != up.URL+"/share" {
+		t.Fatalf("unmatched host: %v %s %v", err, raw, selected)
+	}
+
+	// 请求失败时不选择镜像
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	selected = ""
+	_, err = NewClient().GET(missing.URL+"/share", WithMirror(NewMirror(missing.Listener.Addr().String()), func(u string) {
+		selected = u
+	}))
+	if err == nil || selected != "" {
+		t.Fatalf("failed request selected: %v %v", err, selected)
+	}
+}
+
+func TestCodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req-1")
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"code":"TooManyRequests","message":"slow down"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient().GET(server.URL)
+	err = fmt.Errorf("list failed: %w", err)
+	if !IsRateLimited(err) || IsForbidden(err) || IsNotFound(err) {
+		t.Fatalf("predicate failed: %v", err)
+	}
+
+	var codeErr CodeError
+	if !errors.As(err, &codeErr) {
+		t.Fatalf("not CodeError: %v", err)
+	}
+	if codeErr.RequestID != "req-1" || codeErr.RetryAfter != 3*time.Second ||
+		codeErr.ProviderCode != "TooManyRequests" || codeErr.Attempt != 1 {
+		t.Fatalf("invalid CodeError: %+v", codeErr)
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		raw, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(raw)
+	}))
+	defer server.Close()
+
+	var refreshed, persisted int
+	source := NewTokenSource(Token{AccessToken: "old", RefreshToken: "refresh"}, func(token Token) (Token, error) {
+		refreshed++
+		return Token{AccessToken: "new", Expiry: time.Now().Add(time.Hour)}, nil
+	}, WithTokenPersist(func(token Token) {
+		persisted++
+	}))
+
+	raw, err := NewClient().POST(server.URL, WithBody("body"), WithTokenSource(source))
+	if err != nil || string(raw) != "body" {
+		t.Fatalf("POST failed: %v %s", err, raw)
+	}
+	token, _ := source.Token()
+	if refreshed != 1 || persisted != 1 || token.RefreshToken != "refresh" {
+		t.Fatalf("refreshed=%v persisted=%v token=%+v", refreshed, persisted, token)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	gbk := []byte{0xc4, 0xe3, 0xba, 0xc3} // "你好"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><meta charset="gbk"></head><body>`))
+		_, _ = w.Write(gbk)
+		_, _ = w.Write([]byte(`</body></html>`))
+	}))
+	defer server.Close()
+
+	var detected string
+	raw, err := NewClient().GET(server.URL, WithCharset(func(charset string) {
+		detected = charset
+	}))
+	if err != nil || !strings.Contains(string(raw), "你好") || detected != "gbk" {
+		t.Fatalf("charset failed: %v %v %s", err, detected, raw)
+	}
+
+	val, name, _ := DecodeCharset(gbk, "text/plain", "gb18030")
+	if string(val) != "你好" || name != "gb18030" {
+		t.Fatalf("fallback failed: %v %s", name, val)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	var connects int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connects++
+		w.Header().Set("Content-Type", "text/event-stream")
+		if connects == 1 {
+			_, _ = w.Write([]byte(": comment\nretry: 10\nid: 1\nevent: progress\ndata: {\"progress\":50}\n\n"))
+			return
+		}
+		if r.Header.Get("Last-Event-ID") != "1" {
+			t.Errorf("Last-Event-ID: %v", r.Header.Get("Last-Event-ID"))
+		}
+		_, _ = w.Write([]byte("id: 2\r\ndata: line1\r\ndata: line2\r\n\r\n"))
+	}))
+	defer server.Close()
+
+	var events []Event
+	err := NewClient().EventStream(server.URL, func(event Event) error {
+		events = append(events, event)
+		if len(events) == 2 {
+			return ErrStreamDone
+		}
+		return nil
+	})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("EventStream failed: %v %+v", err, events)
+	}
+	if events[0].Event != "progress" || events[0].Retry != 10*time.Millisecond ||
+		events[1].ID != "2" || string(events[1].Data) != "line1\nline2" {
+		t.Fatalf("invalid events: %+v", events)
+	}
+
+	ndjson := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{\"state\":1}\n\n{\"state\":2}\n"))
+	}))
+	defer ndjson.Close()
+
+	values, errs := NewClient().JSONStreamChan(http.MethodGet, ndjson.URL)
+	var count int
+	for range values {
+		count++
+	}
+	if err = <-errs; err != nil || count != 2 {
+		t.Fatalf("JSONStream failed: %v %v", err, count)
+	}
+
+	// 没有读取 channel 时 WithTimeout 也能结束 goroutine
+	_, errs = NewClient().JSONStreamChan(http.MethodGet, ndjson.URL, WithTimeout(100*time.Millisecond))
+	select {
+	case err = <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("JSONStreamChan timeout: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("JSONStreamChan ignores timeout")
+	}
+}
+
+func TestCurl(t *testing.T) {
+	command := `curl 'https://api.aliyundrive.com/v2/file/list' \
+  -H 'authorization: Bearer abc' \
+  -H $'x-note: it\'s' \
+  -b 'a=1; b=2' \
+  --data-raw '{"limit":100}' \
+  --compressed -k -x 127.0.0.1:8080`
+	method, u, opts, err := ParseCurl(command)
+	if err != nil || method != http.MethodPost || u != "https://api.aliyundrive.com/v2/file/list" {
+		t.Fatalf("ParseCurl failed: %v %v %v", err, method, u)
+	}
+
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt.apply(options)
+	}
+	if options.header["authorization"] != "Bearer abc" || options.header["x-note"] != "it's" ||
+		options.header["Cookie"] != "a=1; b=2" || options.proxy == nil {
+		t.Fatalf("invalid options: %+v", options.header)
+	}
+	raw, _ := io.ReadAll(options.body)
+	if string(raw) != `{"limit":100}` {
+		t.Fatalf("invalid body: %s", raw)
+	}
+
+	request, _ := http.NewRequest(method, u, strings.NewReader(string(raw)))
+	request.Header.Set("X-Note", "it's")
+	export, err := Curl(request)
+	if err != nil {
+		t.Fatalf("Curl failed: %v", err)
+	}
+	method, u, opts, err = ParseCurl(export)
+	options = defaultOptions()
+	for _, opt := range opts {
+		opt.apply(options)
+	}
+	if err != nil || method != http.MethodPost || options.header["X-Note"] != "it's" {
+		t.Fatalf("round trip failed: %v %v %+v", err, export, options.header)
+	}
+
+	// debug 日志中的 body: 二进制和超过限制的 body 使用占位符, 不能重放的 body 不读取
+	client := NewClient(WithDumpBodyLimit(16))
+	for body, expect := range map[io.Reader]string{
+		strings.NewReader(`{"a":1}`):               `--data-binary '{"a":1}'`,
+		bytes.NewReader([]byte{0, 1, 2, 0xff}):     `'@body(4 bytes, binary)'`,
+		strings.NewReader(strings.Repeat("a", 17)): `'@body(17 bytes, truncated)'`,
+		io.MultiReader(strings.NewReader("chunk")): `'@body(unknown size, not replayable)'`,
+	} {
+		request, _ := http.NewRequest(http.MethodPut, "http://127.0.0.1/upload", body)
+		command, err := client.curl(request, defaultOptions())
+		if err != nil || !strings.Contains(command, expect) {
+			t.Fatalf("curl body: %v %v, expect %v", err, command, expect)
+		}
+	}
+	request, _ = http.NewRequest(http.MethodPut, "http://127.0.0.1/upload", io.MultiReader(strings.NewReader("chunk")))
+	client.curl(request, defaultOptions())
+	if raw, _ := io.ReadAll(request.Body); string(raw) != "chunk" {
+		t.Fatalf("request body consumed: %s", raw)
+	}
+}
+
+func TestDumpRedact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"secret","data":{"refresh_token":"secret","name":"tool"}}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient(WithDumpRedact([]string{"X-Custom"}, nil, nil), WithDumpRetention(1, 0))
+	client.config.dir = dir
+	raw, err := client.POST(server.URL+"/api/token?token=secret&id=1", WithDump(),
+		WithBody(map[string]string{"password": "secret", "user": "tool"}),
+		WithHeader(map[string]string{"Authorization": "Bearer secret", "X-Custom": "secret"}))
+	if err != nil || !strings.Contains(string(raw), `"access_token":"secret"`) {
+		t.Fatalf("request failed: %v %s", err, raw)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("invalid dump files: %v", len(entries))
+	}
+	for _, entry := range entries {
+		dump, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if strings.Contains(string(dump), "secret") || !strings.Contains(string(dump), "tool") {
+			t.Fatalf("dump not redacted: %s", dump)
+		}
+	}
+
+	client.config.dump.pruned = time.Time{}
+	client.pruneDump()
+	if entries, _ = os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("dump not pruned: %v", len(entries))
+	}
+
+	redactor := NewRedactor(nil, nil, nil)
+	if body := redactor.Body([]byte("token=secret&a=1"), "application/x-www-form-urlencoded"); string(body) != "a=1&token=%2A%2A%2A" {
+		t.Fatalf("form not redacted: %s", body)
+	}
+	limit := &dumpConfig{bodyLimit: 4}
+	if body := limit.limitBody([]byte("0123"), 10); string(body) != "0123\n... (6 bytes truncated)" {
+		t.Fatalf("invalid limit: %s", body)
+	}
+
+	// 截断的 JSON 按照字段名隐藏, 无法解析的 JSON 使用占位符
+	for raw, expect := range map[string]string{
+		`{"a":[1,{"token":"secret"}],"password": {"x":"secr`: `{"a":[1,{"token":"***"}],"password": "***"`,
+		`{"name":"tool","pwd":"sec`:                         `{"name":"tool","pwd":"***"`,
+		`token=secret&a=1&pwd=se%`:                          `a=1&token=%2A%2A%2A`,
+	} {
+		contentType := "application/json"
+		if !strings.HasPrefix(raw, "{") {
+			contentType = "application/x-www-form-urlencoded"
+		}
+		if body := redactor.Body([]byte(raw), contentType); string(body) != expect {
+			t.Fatalf("partial body not redacted: %s", body)
+		}
+	}
+	limit.redactor = redactor
+	if body := limit.redactBody([]byte(`callback({"token":"secret"})`), "application/json", 100); string(body) != "(100 bytes, unparseable, redacted)" {
+		t.Fatalf("invalid placeholder: %s", body)
+	}
+
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"secret","data":"%v"}`, strings.Repeat("a", 1024))
+	}))
+	defer large.Close()
+
+	dir = t.TempDir()
+	client = NewClient(WithDumpBodyLimit(64))
+	client.config.dir = dir
+	if _, err = client.GET(large.URL+"/large", WithDump()); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if entries, _ = os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("invalid dump files: %v", len(entries))
+	}
+	for _, entry := range entries {
+		dump, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if strings.Contains(string(dump), "secret") {
+			t.Fatalf("large dump not redacted: %s", dump)
+		}
+		if strings.HasSuffix(entry.Name(), "RESP.txt") && !strings.Contains(string(dump), "bytes truncated") {
+			t.Fatalf("large dump not truncated: %s", dump)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/attempt":
+			if atomic.AddInt32(&count, 1) == 1 {
+				time.Sleep(500 * time.Millisecond)
+			}
+			fmt.Fprint(w, "ok")
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+			fmt.Fprint(w, "ok")
+		case "/file":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	raw, err := client.GET(server.URL+"/attempt", WithRetry(1), WithAttemptTimeout(100*time.Millisecond))
+	if err != nil || string(raw) != "ok" || atomic.LoadInt32(&count) != 2 {
+		t.Fatalf("attempt timeout retry failed: %v %s %v", err, raw, count)
+	}
+
+	now := time.Now()
+	_, err = client.GET(server.URL+"/slow", WithRetry(3), WithTimeout(300*time.Millisecond),
+		WithAttemptTimeout(100*time.Millisecond))
+	if !IsTimeout(err) || time.Since(now) > time.Second {
+		t.Fatalf("total timeout failed: %v %v", err, time.Since(now))
+	}
+
+	reader, err := client.File(server.URL+"/file", http.MethodGet, WithAttemptTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("file failed: %v", err)
+	}
+	raw, err = io.ReadAll(reader)
+	reader.(io.Closer).Close()
+	if err != nil || string(raw) != "ok" {
+		t.Fatalf("file body failed: %v %s", err, raw)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		mu.Lock()
+		remotes[host] = true
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	client := NewClient(WithClientLocalAddr("127.0.0.2", "127.0.0.3", "::1"), WithClientIPFamily(IPv4Prefer))
+	for i := 0; i < 4; i++ {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if len(remotes) != 2 || !remotes["127.0.0.2"] || !remotes["127.0.0.3"] {
+		t.Fatalf("local addr not rotated: %v", remotes)
+	}
+
+	if _, err := client.GET(server.URL, WithIPFamily(IPv6Only)); err == nil {
+		t.Fatalf("ipv6 only should fail")
+	}
+	if _, err := client.GET(server.URL, WithLocalAddr("127.0.0.4")); err != nil || !remotes["127.0.0.4"] {
+		t.Fatalf("request local addr failed: %v %v", err, remotes)
+	}
+
+	bind := &bindConfig{family: IPv6Prefer}
+	ips := bind.filter([]net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("::1"), net.ParseIP("2.2.2.2")})
+	if ips[0].String() != "::1" || ips[1].String() != "1.1.1.1" {
+		t.Fatalf("invalid prefer order: %v", ips)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host+" "+r.TLS.ServerName)
+	}))
+	defer server.Close()
+
+	uRL, _ := url.Parse(server.URL)
+	client := NewClient(WithClientResolve("example.test:"+uRL.Port()+":127.0.0.1", "*.example.test:*:127.0.0.1", "bad"))
+	raw, err := client.GET("https://example.test:" + uRL.Port())
+	if err != nil || string(raw) != "example.test:"+uRL.Port()+" example.test" {
+		t.Fatalf("resolve failed: %v %s", err, raw)
+	}
+	raw, err = client.GET("https://api.example.test:" + uRL.Port())
+	if err != nil || string(raw) != "api.example.test:"+uRL.Port()+" api.example.test" {
+		t.Fatalf("wildcard resolve failed: %v %s", err, raw)
+	}
+
+	table := newResolveTable()
+	table.add("*:443:1.1.1.1", "a.b.c:*:2.2.2.2", "*.c:443:3.3.3.3")
+	for host, expect := range map[string]string{"a.b.c": "2.2.2.2", "x.b.c": "3.3.3.3", "d": "1.1.1.1"} {
+		if ips, ok := table.lookup(host, "443"); !ok || ips[0].String() != expect {
+			t.Fatalf("invalid lookup %v: %v", host, ips)
+		}
+	}
+	if _, ok := table.lookup("d", "80"); ok {
+		t.Fatalf("port should not match")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("X-Lang", r.Header.Get("X-Lang"))
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lang := []string{"en", "zh"}[i%2]
+			raw, header, err := client.Request(http.MethodGet, server.URL, WithCoalesce("X-Lang"),
+				WithHeader(map[string]string{"X-Lang": lang}))
+			if err != nil || string(raw) != "ok" || header.Get("X-Lang") != lang {
+				t.Errorf("coalesce failed: %v %s %v", err, raw, header)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&count) != 2 {
+		t.Fatalf("requests not coalesced: %v", count)
+	}
+
+	// 等待共享请求时使用自己的超时
+	leader := make(chan error, 1)
+	go func() {
+		_, err := client.GET(server.URL, WithCoalesce())
+		leader <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	_, err := client.GET(server.URL, WithCoalesce(), WithTimeout(50*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 120*time.Millisecond {
+		t.Fatalf("follower timeout: %v %v", err, time.Since(start))
+	}
+	if err = <-leader; err != nil {
+		t.Fatalf("leader: %v", err)
+	}
+
+	// 改变响应的选项不合并
+	atomic.StoreInt32(&count, 0)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := []Option{WithCoalesce()}
+			if i == 0 {
+				opts = append(opts, WithCharset(nil, "gb18030"))
+			}
+			if _, err := client.GET(server.URL, opts...); err != nil {
+				t.Errorf("GET: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&count) != 2 {
+		t.Fatalf("charset request coalesced: %v", count)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	raw := []byte(`{
+		"default": {"dns_timeout": "5s", "retry": 2, "dns": ["223.5.5.5:53"]},
+		"aliyundrive": {"proxy": "127.0.0.1:8080", "ip_family": "ipv4_only", "rate_limit": 5, "rate_burst": 10}
+	}`)
+	values, err := parseProfiles("client.json", raw, []string{
+		"TOOL_CONN_TIMEOUT=20s", "TOOL_ALIYUNDRIVE__RETRY=3", "TOOL_LOG=debug", "TOOL_dns_timeout=1s",
+		"TOOL_FOO__BAR=1", "TOOL_RESOLVE=example.com:443:127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(values[DefaultProfile].Resolve) != 0 {
+		t.Fatalf("TOOL_RESOLVE used by default profile: %v", values[DefaultProfile].Resolve)
+	}
+	profile := values["aliyundrive"]
+	if profile.DNSTimeout != 5*time.Second || profile.ConnTimeout != 20*time.Second || profile.Retry != 3 ||
+		profile.IPFamily != IPv4Only || profile.RateBurst != 10 || values[DefaultProfile].Retry != 2 {
+		t.Fatalf("invalid profile: %+v", profile)
+	}
+
+	client := NewClient(profile.Options()...)
+	if client.config.retry != 3 || client.config.limiter == nil || client.config.bind.family != IPv4Only {
+		t.Fatalf("invalid client config: %+v", client.config)
+	}
+
+	limiter := newRateLimiter(10, 1)
+	if limiter.reserve() != 0 || limiter.reserve() < 90*time.Millisecond {
+		t.Fatalf("invalid rate limiter")
+	}
+
+	// 每个 client 使用自己的令牌桶
+	RegisterRateLimit(10, 1)
+	defer RegisterRateLimit(0, 0)
+	a, b := NewClient(), NewClient()
+	if a.config.limiter == globalClient.config.limiter || a.config.limiter == b.config.limiter ||
+		a.config.limiter.reserve() != 0 || b.config.limiter.reserve() != 0 {
+		t.Fatalf("rate limiter shared")
+	}
+
+	for _, test := range []struct {
+		raw     string
+		environ []string
+		expect  string
+	}{
+		{`{"default": {"retry": "x"}}`, nil, "client.json: default.retry: invalid retry"},
+		{`{"drive": {"conn_timeout": "10"}}`, nil, "client.json: drive.conn_timeout: invalid duration"},
+		{`{"drive": {"unknown": 1}}`, nil, "client.json: drive.unknown: unknown key"},
+		{`{}`, []string{"TOOL_DRIVE__PROXY=http://"}, "env TOOL_DRIVE__PROXY: drive.proxy: invalid proxy"},
+		{`{"drive": {"tls_key_file": "a.key"}}`, nil, "client.json: drive.tls_cert_file: required by tls_key_file"},
+	} {
+		_, err := parseProfiles("client.json", []byte(test.raw), test.environ)
+		var profileErr *ProfileError
+		if !errors.As(err, &profileErr) || !strings.HasPrefix(err.Error(), test.expect) {
+			t.Fatalf("expect %q, got %v", test.expect, err)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	var running, peak int32
+	values, err := Map(context.Background(), 20, func(ctx context.Context, i int) (interface{}, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return i * i, nil
+	}, WithPoolConcurrency(3))
+	if err != nil || peak > 3 || values[7].(int) != 49 {
+		t.Fatalf("map failed: %v %v %v", err, peak, values)
+	}
+
+	fail := errors.New("fail")
+	var count int32
+	_, err = Map(context.Background(), 20, func(ctx context.Context, i int) (interface{}, error) {
+		atomic.AddInt32(&count, 1)
+		if i == 0 {
+			return nil, fail
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	}, WithPoolConcurrency(2))
+	if err != fail || atomic.LoadInt32(&count) >= 20 {
+		t.Fatalf("fail fast failed: %v %v", err, count)
+	}
+
+	_, err = Map(context.Background(), 4, func(ctx context.Context, i int) (interface{}, error) {
+		if i%2 == 0 {
+			return nil, fmt.Errorf("task %v: %w", i, fail)
+		}
+		return nil, nil
+	}, WithPoolMode(BestEffort))
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 || !errors.Is(err, fail) {
+		t.Fatalf("best effort failed: %v", err)
+	}
+
+	// 任务中提交任务
+	var total int32
+	pool := NewPool(context.Background(), WithPoolConcurrency(2))
+	var walk func(depth int)
+	walk = func(depth int) {
+		for i := 0; i < 2 && depth < 4; i++ {
+			pool.Go(func(ctx context.Context) error {
+				atomic.AddInt32(&total, 1)
+				walk(depth + 1)
+				return nil
+			})
+		}
+	}
+	walk(0)
+	if err = pool.Wait(); err != nil || total != 30 {
+		t.Fatalf("nested submit failed: %v %v", err, total)
+	}
+
+	seen := make(map[int]bool)
+	for result := range Stream(context.Background(), 5, func(ctx context.Context, i int) (interface{}, error) {
+		return i, nil
+	}) {
+		seen[result.Index] = result.Value.(int) == result.Index
+	}
+	if len(seen) != 5 {
+		t.Fatalf("stream failed: %v", seen)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	// 最小的 echo 服务: 先发送 ping, 收到 pong 之后原样返回消息(包括 RSV1 压缩位)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+		conn, rw, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %v\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+		if strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+			fmt.Fprintf(rw, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+		}
+		fmt.Fprintf(rw, "\r\n")
+		rw.Write([]byte{0x89, 2, 'h', 'i'})
+		rw.Flush()
+
+		for {
+			var header [2]byte
+			if _, err := io.ReadFull(rw, header[:]); err != nil {
+				return
+			}
+			length := int(header[1] & 0x7f)
+			if length == 126 {
+				var ext [2]byte
+				io.ReadFull(rw, ext[:])
+				length = int(ext[0])<<8 | int(ext[1])
+			}
+			var mask [4]byte
+			io.ReadFull(rw, mask[:])
+			payload := make([]byte, length)
+			io.ReadFull(rw, payload)
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+
+			opcode := header[0] & 0x0f
+			if opcode == opPong {
+				if string(payload) != "hi" {
+					return
+				}
+				continue
+			}
+			frame := []byte{header[0], byte(length)}
+			if length > 125 {
+				frame = []byte{header[0], 126, byte(length >> 8), byte(length)}
+			}
+			rw.Write(append(frame, payload...))
+			rw.Flush()
+			if opcode == opClose {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	u := "ws" + strings.TrimPrefix(server.URL, "http")
+	for _, compress := range []bool{false, true} {
+		opts := []Option{WithWSPing(-1)}
+		if compress {
+			opts = append(opts, WithWSCompression())
+		}
+		ws, err := DialWebSocket(u, opts...)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		text := strings.Repeat("hello websocket ", 20)
+		if err = ws.WriteMessage(ctx, TextMessage, []byte(text)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		typ, data, err := ws.ReadMessage(ctx)
+		if err != nil || typ != TextMessage || string(data) != text {
+			t.Fatalf("read failed: %v %v %q", err, typ, data)
+		}
+		if err = ws.WriteMessage(ctx, BinaryMessage, []byte{1, 2, 3}); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if typ, data, err = ws.ReadMessage(ctx); err != nil || typ != BinaryMessage || len(data) != 3 {
+			t.Fatalf("read failed: %v %v %v", err, typ, data)
+		}
+		if ws.compress != compress {
+			t.Fatalf("compression negotiate failed: %v", ws.compress)
+		}
+		cancel()
+
+		// ctx 结束时 ReadMessage 返回
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if _, _, err = ws.ReadMessage(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("read with ctx failed: %v", err)
+		}
+		cancel()
+		ws.Close()
+	}
+
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	var codeErr CodeError
+	if _, err := DialWebSocket(plain.URL); !errors.As(err, &codeErr) {
+		t.Fatalf("expect handshake error: %v", err)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var ids []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get(DefaultRequestIDHeader)+"|"+r.Header.Get("X-Trace-Id"))
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	log.SetModuleLevel("util", log.DebugLevel)
+	defer log.ResetModuleLevel("util")
+	sub := log.Subscribe(log.WithLevels(log.DebugLevel))
+	defer log.UnSubscribe(sub)
+
+	header := map[string]string{"accept": "text/plain"}
+	ctx := log.WithContext(context.Background(), log.With("job", "j1"))
+	var inner string
+	_, err := GET(server.URL, WithHeader(header), WithContext(ctx), WithBeforeRequest(func(r *http.Request) {
+		inner = RequestID(r.Context())
+	}))
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	if len(header) != 1 {
+		t.Fatalf("caller header modified: %v", header)
+	}
+	if len(ids) != 1 || inner == "" || ids[0] != inner+"|" {
+		t.Fatalf("request id: %v %q", ids, inner)
+	}
+
+	timeout := time.After(time.Second)
+	for found := false; !found; {
+		select {
+		case event := <-sub.Events():
+			if event.Logger == "util" && event.Fields["request_id"] == inner {
+				if event.Fields["job"] != "j1" {
+					t.Fatalf("job not in request log: %+v", event)
+				}
+				found = true
+			}
+		case <-timeout:
+			t.Fatalf("no request log")
+		}
+	}
+
+	// 自定义 header, 调用者设置的值作为请求 ID
+	client := NewClient(WithClientRequestIDHeader("X-Trace-Id"))
+	_, err = client.GET(server.URL, WithHeader(map[string]string{"x-trace-id": "t1"}), WithBeforeRequest(func(r *http.Request) {
+		inner = RequestID(r.Context())
+	}))
+	if err != nil || inner != "t1" || ids[1] != "|t1" {
+		t.Fatalf("custom header: %v %q %v", err, inner, ids)
+	}
+}
@@ -0,0 +1,596 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	defaultWSPing      = 30 * time.Second
+	defaultWSReadLimit = 32 * 1024 * 1024
+)
+
+// MessageType WebSocket 消息类型
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// close code, RFC 6455 7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	CloseMessageTooLarge = 1009
+)
+
+var ErrWSClosed = errors.New("websocket closed")
+
+// CloseError 对端发送的 close frame
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: code=%v reason=%q", e.Code, e.Reason)
+}
+
+type wsConfig struct {
+	protocols []string
+	compress  bool
+	ping      time.Duration
+	readLimit int64
+}
+
+// WithWSProtocols 设置 Sec-WebSocket-Protocol
+func WithWSProtocols(protocols ...string) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.ws.protocols = protocols
+	})
+}
+
+// WithWSCompression 协商 permessage-deflate 压缩
+func WithWSCompression() Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.ws.compress = true
+	})
+}
+
+// WithWSPing 每隔 interval 发送 ping, 超过 2*interval 没有收到任何数据时关闭连接. interval < 0 表示不发送 ping, 默认 30s.
+func WithWSPing(interval time.Duration) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.ws.ping = interval
+	})
+}
+
+// WithWSReadLimit 单个消息的最大长度, 默认 32M
+func WithWSReadLimit(limit int64) Option {
+	return newFuncDialOption(func(o *httpOptions) {
+		o.ws.readLimit = limit
+	})
+}
+
+// WSConn WebSocket 连接. ReadMessage 和 WriteMessage 可以在不同的 goroutine 中并发调用.
+// ping 的响应在 ReadMessage 中处理, 因此需要持续调用 ReadMessage.
+type WSConn struct {
+	conn     io.ReadWriteCloser
+	reader   *bufio.Reader
+	header   http.Header
+	protocol string
+	compress bool
+	limit    int64
+
+	rmu sync.Mutex
+	wmu sync.Mutex
+
+	mu       sync.Mutex
+	received time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func DialWebSocket(u string, opts ...Option) (*WSConn, error) {
+	return globalClient.DialWebSocket(u, opts...)
+}
+
+// wsTransport 返回用于握手的 transport: 使用 client 的 DNS, 代理, 本地地址和 TLS 配置, 只使用 HTTP/1.1,
+// 连接不使用 timeoutConn(长时间空闲由 ping 检测).
+func (c *EmbedClient) wsTransport(options *httpOptions) (http.RoundTripper, error) {
+	var base *http.Transport
+	var config *clientConfig
+	switch transport := c.Client.Transport.(type) {
+	case *http.Transport:
+		base = transport
+	case *customerTransport:
+		base, _ = transport.Transport.(*http.Transport)
+		config = transport.config
+	}
+	if base == nil {
+		return nil, errors.New("websocket requires *http.Transport")
+	}
+
+	clone := base.Clone()
+	clone.ForceAttemptHTTP2 = false
+	clone.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	clone.TLSClientConfig = c.tlsConfig()
+	clone.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	clone.DisableCompression = true
+	clone.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return c.dial(ctx, options.bind, network, addr)
+	}
+	if options.proxy != nil {
+		clone.Proxy = options.proxy
+	} else if options.proxyDail != nil {
+		clone.Proxy = nil
+		clone.DialContext = options.proxyDail
+	}
+
+	if config != nil {
+		return &customerTransport{Transport: clone, config: config}, nil
+	}
+	return clone, nil
+}
+
+// DialWebSocket 建立 WebSocket 连接(ws, wss, http, https). 支持 WithHeader, WithContext, WithTimeout, WithProxy,
+// WithLocalAddr, WithTokenSource 等请求参数, cookie 使用 client 的 cookie jar.
+func (c *EmbedClient) DialWebSocket(u string, opts ...Option) (*WSConn, error) {
+	c.init()
+
+	options := c.options(opts)
+	defer options.withTimeout()()
+	if options.ws.ping == 0 {
+		options.ws.ping = defaultWSPing
+	}
+	if options.ws.readLimit <= 0 {
+		options.ws.readLimit = defaultWSReadLimit
+	}
+
+	uRL, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	switch uRL.Scheme {
+	case "ws":
+		uRL.Scheme = "http"
+	case "wss":
+		uRL.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid websocket scheme %q", uRL.Scheme)
+	}
+
+	transport, err := c.wsTransport(options)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request, err := http.NewRequestWithContext(options.ctx, http.MethodGet, uRL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range options.header {
+		request.Header.Set(k, v)
+	}
+	if request.Header.Get("User-Agent") == "" {
+		request.Header.Set("User-Agent", hashUserAgent(u))
+	}
+	if options.tokenSource != nil {
+		token, err := options.tokenSource.Token()
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", token.Type()+" "+token.AccessToken)
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	if len(options.ws.protocols) > 0 {
+		request.Header.Set("Sec-WebSocket-Protocol", strings.Join(options.ws.protocols, ", "))
+	}
+	if options.ws.compress {
+		request.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_no_context_takeover; server_no_context_takeover")
+	}
+	if options.beforeRequest != nil {
+		options.beforeRequest(request)
+	}
+
+	if err = c.config.limiter.wait(options.ctx); err != nil {
+		return nil, err
+	}
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	if options.afterResponse != nil {
+		options.afterResponse(response)
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		raw, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+		response.Body.Close()
+		return nil, newCodeError(http.MethodGet, u, response, raw, 1)
+	}
+	conn, ok := response.Body.(io.ReadWriteCloser)
+	if !ok {
+		response.Body.Close()
+		return nil, errors.New("websocket: upgraded body is not writable")
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if response.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, errors.New("websocket: invalid Sec-WebSocket-Accept")
+	}
+
+	ws := &WSConn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		header:   response.Header,
+		protocol: response.Header.Get("Sec-WebSocket-Protocol"),
+		limit:    options.ws.readLimit,
+		received: time.Now(),
+		closed:   make(chan struct{}),
+	}
+	for _, ext := range response.Header.Values("Sec-WebSocket-Extensions") {
+		for _, v := range strings.Split(ext, ",") {
+			if strings.TrimSpace(strings.Split(v, ";")[0]) == "permessage-deflate" {
+				if !options.ws.compress {
+					conn.Close()
+					return nil, errors.New("websocket: unexpected permessage-deflate")
+				}
+				ws.compress = true
+			}
+		}
+	}
+
+	if options.ws.ping > 0 {
+		go ws.keepalive(options.ws.ping)
+	}
+	return ws, nil
+}
+
+// Header 握手响应的 header
+func (ws *WSConn) Header() http.Header {
+	return ws.header
+}
+
+// Subprotocol 服务端选择的 Sec-WebSocket-Protocol
+func (ws *WSConn) Subprotocol() string {
+	return ws.protocol
+}
+
+func (ws *WSConn) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.closed:
+			return
+		case <-ticker.C:
+			ws.mu.Lock()
+			received := ws.received
+			ws.mu.Unlock()
+			if time.Since(received) > 2*interval {
+				ws.conn.Close()
+				ws.shutdown()
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := ws.writeFrame(ctx, opPing, nil, false)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ws *WSConn) shutdown() {
+	ws.closeOnce.Do(func() {
+		close(ws.closed)
+	})
+}
+
+// watch ctx 结束时关闭连接, 返回的函数停止监听
+func (ws *WSConn) watch(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exit := make(chan struct{})
+	go func() {
+		defer close(exit)
+		select {
+		case <-ctx.Done():
+			ws.conn.Close()
+			ws.shutdown()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exit
+	}
+}
+
+func (ws *WSConn) writeFrame(ctx context.Context, opcode int, payload []byte, compressed bool) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	select {
+	case <-ws.closed:
+		return ErrWSClosed
+	default:
+	}
+
+	var header [14]byte
+	header[0] = 0x80 | byte(opcode)
+	if compressed {
+		header[0] |= 0x40
+	}
+	n := 2
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 65535:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+		n += 2
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+		n += 8
+	}
+	header[1] |= 0x80
+	mask := header[n : n+4]
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	n += 4
+
+	frame := make([]byte, n+len(payload))
+	copy(frame, header[:n])
+	for i, b := range payload {
+		frame[n+i] = b ^ mask[i%4]
+	}
+
+	stop := ws.watch(ctx)
+	_, err := ws.conn.Write(frame)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// WriteMessage 发送消息, ctx 结束时关闭连接
+func (ws *WSConn) WriteMessage(ctx context.Context, messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("invalid message type %v", messageType)
+	}
+	if !ws.compress {
+		return ws.writeFrame(ctx, int(messageType), data, false)
+	}
+
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	// 去掉 sync flush 的 0x00 0x00 0xff 0xff
+	payload := bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff})
+	return ws.writeFrame(ctx, int(messageType), payload, true)
+}
+
+// Ping 发送 ping, pong 在 ReadMessage 中处理
+func (ws *WSConn) Ping(ctx context.Context, data []byte) error {
+	if len(data) > 125 {
+		return errors.New("ping payload too long")
+	}
+	return ws.writeFrame(ctx, opPing, data, false)
+}
+
+// readFrame 读取一个 frame, 返回 fin, rsv1, opcode 以及 payload
+func (ws *WSConn) readFrame(remain int64) (fin, rsv1 bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(ws.reader, header[:]); err != nil {
+		return
+	}
+	fin, rsv1, opcode = header[0]&0x80 != 0, header[0]&0x40 != 0, int(header[0]&0x0f)
+	if header[0]&0x30 != 0 {
+		return fin, rsv1, opcode, nil, errors.New("websocket: unexpected rsv bits")
+	}
+	if header[1]&0x80 != 0 {
+		return fin, rsv1, opcode, nil, errors.New("websocket: masked server frame")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if opcode >= opClose && (length > 125 || !fin) {
+		return fin, rsv1, opcode, nil, errors.New("websocket: invalid control frame")
+	}
+	if length < 0 || length > remain {
+		return fin, rsv1, opcode, nil, errMessageTooLarge
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(ws.reader, payload)
+	return
+}
+
+var errMessageTooLarge = errors.New("websocket: message too large")
+
+// ReadMessage 读取一个完整的消息, 同时处理 ping, pong 和 close. ctx 结束时关闭连接.
+func (ws *WSConn) ReadMessage(ctx context.Context) (MessageType, []byte, error) {
+	ws.rmu.Lock()
+	defer ws.rmu.Unlock()
+
+	stop := ws.watch(ctx)
+	messageType, data, err := ws.readMessage(ctx)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+	return messageType, data, err
+}
+
+func (ws *WSConn) readMessage(ctx context.Context) (MessageType, []byte, error) {
+	var messageType MessageType
+	var compressed bool
+	var message []byte
+	for {
+		fin, rsv1, opcode, payload, err := ws.readFrame(ws.limit - int64(len(message)))
+		if err != nil {
+			if err == errMessageTooLarge {
+				ws.CloseWithReason(CloseMessageTooLarge, "")
+			}
+			return 0, nil, err
+		}
+		ws.mu.Lock()
+		ws.received = time.Now()
+		ws.mu.Unlock()
+
+		switch opcode {
+		case opPing:
+			if err = ws.writeFrame(ctx, opPong, payload, false); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			echo := payload
+			if len(echo) >= 2 {
+				echo = payload[:2]
+			}
+			_ = ws.writeFrame(context.Background(), opClose, echo, false)
+			ws.shutdown()
+			ws.conn.Close()
+			return 0, nil, closeErr
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, errors.New("websocket: unexpected continuation frame")
+			}
+		case int(TextMessage), int(BinaryMessage):
+			if messageType != 0 {
+				return 0, nil, errors.New("websocket: expect continuation frame")
+			}
+			messageType = MessageType(opcode)
+			compressed = rsv1
+			if compressed && !ws.compress {
+				return 0, nil, errors.New("websocket: unexpected compressed frame")
+			}
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %v", opcode)
+		}
+
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+
+		if compressed {
+			reader := flate.NewReader(io.MultiReader(bytes.NewReader(message),
+				bytes.NewReader([]byte{0, 0, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff})))
+			message, err = io.ReadAll(io.LimitReader(reader, ws.limit+1))
+			reader.Close()
+			if err != nil {
+				return 0, nil, err
+			}
+			if int64(len(message)) > ws.limit {
+				ws.CloseWithReason(CloseMessageTooLarge, "")
+				return 0, nil, errMessageTooLarge
+			}
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			ws.CloseWithReason(CloseInvalidPayload, "")
+			return 0, nil, errors.New("websocket: invalid utf-8 text message")
+		}
+		return messageType, message, nil
+	}
+}
+
+// CloseWithReason 发送 close frame 并关闭连接
+func (ws *WSConn) CloseWithReason(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := ws.writeFrame(ctx, opClose, payload, false)
+	ws.shutdown()
+	if closeErr := ws.conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err == ErrWSClosed {
+		err = nil
+	}
+	return err
+}
+
+func (ws *WSConn) Close() error {
+	return ws.CloseWithReason(CloseNormal, "")
+}